@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// File locking is not supported here, so every instance gets the lock.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
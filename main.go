@@ -1,19 +1,54 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/term"
+	"github.com/fsnotify/fsnotify"
+	"github.com/muesli/termenv"
+	"golang.org/x/text/unicode/norm"
+	_ "modernc.org/sqlite"
 )
 
 // Data Structure --------------------------------------------------------------
@@ -24,358 +59,4588 @@ type Memo struct {
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Archived  bool       `json:"archived,omitempty"`
+	Starred   bool       `json:"starred,omitempty"`
+	// Daily is the day, as YYYY-MM-DD, of a daily note.
+	Daily string `json:"daily,omitempty"`
 }
 
 func (m Memo) FilterValue() string { return m.Content }
 
-func (m Memo) Title() string {
-	if idx := strings.IndexByte(m.Content, '\n'); idx != -1 {
-		return truncate(m.Content[:idx], 50)
-	}
-	if len(m.Content) == 0 {
-		return "(empty memo)"
+// equal reports whether two memos have the same fields, comparing times by
+// the instant they describe.
+func (m Memo) equal(o Memo) bool {
+	if (m.DeletedAt == nil) != (o.DeletedAt == nil) ||
+		m.DeletedAt != nil && !m.DeletedAt.Equal(*o.DeletedAt) {
+		return false
 	}
-	return truncate(m.Content, 50)
+	return m.ID == o.ID && m.Content == o.Content &&
+		m.CreatedAt.Equal(o.CreatedAt) && m.UpdatedAt.Equal(o.UpdatedAt) &&
+		m.Archived == o.Archived && m.Starred == o.Starred && m.Daily == o.Daily
 }
 
-func (m Memo) Description() string { return m.UpdatedAt.Format("2006-01-02 15:04:05") }
+// Title is the first non-blank line, without a Markdown heading marker.
+func (m Memo) Title() string {
+	for line := range strings.Lines(m.Content) {
+		line = strings.TrimSpace(line)
+		if heading, ok := markdownHeading(line); ok {
+			line = heading
+		}
+		if line != "" {
+			return truncate(line, 50)
+		}
+	}
+	return "(empty memo)"
+}
 
-type MemoData struct {
-	Active  []Memo `json:"active"`
-	Deleted []Memo `json:"deleted"`
+// markdownHeading returns the text of a Markdown heading like "## Plans". A
+// hashtag like "#plans" is not a heading.
+func markdownHeading(line string) (string, bool) {
+	text := strings.TrimLeft(line, "#")
+	if text == line || (text != "" && text[0] != ' ' && text[0] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(text), true
 }
 
-// Data Persistence ------------------------------------------------------------
+func (m Memo) Description() string { return m.UpdatedAt.Format(timeFormat) }
 
-type Storage struct{ filepath string }
+// timeFormat is the Go time layout timestamps are shown in, set from the
+// config at startup.
+var timeFormat = DefaultConfig().TimeFormat
 
-func NewStorage(filepath string) *Storage {
-	return &Storage{filepath}
-}
+var tagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
 
-func (s *Storage) Load() (*MemoData, error) {
-	data, err := os.ReadFile(s.filepath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &MemoData{Active: make([]Memo, 0, 16), Deleted: make([]Memo, 0, 8)}, nil
-		}
-		return nil, err
+// Tags returns the lowercased, de-duplicated #hashtags found in the content,
+// in order of first appearance.
+func (m Memo) Tags() []string {
+	matches := tagPattern.FindAllStringSubmatch(m.Content, -1)
+	if len(matches) == 0 {
+		return nil
 	}
 
-	var memoData MemoData
-	if err := json.Unmarshal(data, &memoData); err != nil {
-		var memos []Memo
-		if err := json.Unmarshal(data, &memos); err != nil {
-			return nil, err
+	tags := make([]string, 0, len(matches))
+	seen := make(map[string]struct{}, len(matches))
+	for _, match := range matches {
+		tag := strings.ToLower(match[1])
+		if _, ok := seen[tag]; ok {
+			continue
 		}
-		return &MemoData{Active: memos, Deleted: make([]Memo, 0, 8)}, nil
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
 	}
+	return tags
+}
 
-	cutoff := time.Now().Add(-7 * 24 * time.Hour)
-	n := 0
-	for i := range memoData.Deleted {
-		if memoData.Deleted[i].DeletedAt != nil && memoData.Deleted[i].DeletedAt.After(cutoff) {
-			memoData.Deleted[n] = memoData.Deleted[i]
-			n++
+var duePattern = regexp.MustCompile(`(?:^|\s)due:(\d{4}-\d{2}-\d{2})\b`)
+
+// Due returns the date of the first valid "due:YYYY-MM-DD" token in the memo.
+func (m Memo) Due() (time.Time, bool) {
+	for _, match := range duePattern.FindAllStringSubmatch(m.Content, -1) {
+		if due, err := time.ParseInLocation("2006-01-02", match[1], time.Local); err == nil {
+			return due, true
 		}
 	}
+	return time.Time{}, false
+}
 
-	if n != len(memoData.Deleted) {
-		memoData.Deleted = memoData.Deleted[:n]
-		go func() {
-			if err := s.Save(&memoData); err != nil {
-				log.Printf("Warning: failed to save cleaned deleted memos: %v", err)
-			}
-		}()
+func (m Memo) HasTag(tag string) bool {
+	for _, t := range m.Tags() {
+		if t == tag {
+			return true
+		}
 	}
+	return false
+}
 
-	return &memoData, nil
+type tagItem struct {
+	name  string
+	count int
 }
 
-func (s *Storage) Save(data *MemoData) error {
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
+func (t tagItem) FilterValue() string { return t.name }
+func (t tagItem) Title() string       { return "#" + t.name }
+func (t tagItem) Description() string {
+	if t.count == 1 {
+		return "1 memo"
 	}
-	return os.WriteFile(s.filepath, jsonData, 0644)
+	return fmt.Sprintf("%d memos", t.count)
 }
 
-// Path Helpers ----------------------------------------------------------------
+// templateItem is a named template from the config, described by its first
+// line.
+type templateItem struct {
+	name, content string
+}
 
-func getDataFilePath(filename string) (string, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("failed to get user home directory: %w", err)
-	}
+func (t templateItem) FilterValue() string { return t.name }
+func (t templateItem) Title() string       { return t.name }
+func (t templateItem) Description() string { return Memo{Content: t.content}.Title() }
 
-	// Create .config/yellow directory if it doesn't exist
-	configDir := filepath.Join(homeDir, ".config", "yellow")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create config directory: %w", err)
+// expandTemplate fills in the placeholders of a template: {{date}} and
+// {{time}} with the date and time of now, {{datetime}} with both in
+// dateFormat, and {{weekday}} with the day's name.
+func expandTemplate(content string, now time.Time, dateFormat string) string {
+	return strings.NewReplacer(
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04"),
+		"{{datetime}}", now.Format(dateFormat),
+		"{{weekday}}", now.Weekday().String(),
+	).Replace(content)
+}
+
+// dailyTemplate seeds a new daily note unless the config has a template
+// named "daily".
+const dailyTemplate = "# {{weekday}} {{date}}\n\n"
+
+// findDaily returns the daily note for the day of now.
+func findDaily(memos []Memo, now time.Time) (Memo, bool) {
+	day := now.Format("2006-01-02")
+	for _, memo := range memos {
+		if memo.Daily == day {
+			return memo, true
+		}
 	}
+	return Memo{}, false
+}
 
-	return filepath.Join(configDir, filename), nil
+// currentSchemaVersion is the MemoData layout written by this version.
+const currentSchemaVersion = 3
+
+// defaultNotebook holds memos written before notebooks existed, and any memo
+// added without naming a notebook.
+const defaultNotebook = "default"
+
+type Notebook struct {
+	Active   []Memo `json:"active"`
+	Deleted  []Memo `json:"deleted"`
+	Archived []Memo `json:"archived"`
 }
 
-// Model -----------------------------------------------------------------------
+func newNotebook() *Notebook {
+	return &Notebook{
+		Active:   make([]Memo, 0, 16),
+		Deleted:  make([]Memo, 0, 8),
+		Archived: make([]Memo, 0, 8),
+	}
+}
 
-type ViewMode uint8
+type MemoData struct {
+	SchemaVersion int                  `json:"schema_version"`
+	Notebooks     map[string]*Notebook `json:"notebooks"`
 
-const (
-	ViewModeList ViewMode = iota
-	ViewModeEdit
-)
+	// Before version 3 there was a single collection of memos at the top
+	// level. migrate moves it into the default notebook.
+	LegacyActive   []Memo `json:"active,omitempty"`
+	LegacyDeleted  []Memo `json:"deleted,omitempty"`
+	LegacyArchived []Memo `json:"archived,omitempty"`
+}
 
-type Model struct {
-	list     list.Model
-	textarea textarea.Model
-	storage  *Storage
+func newMemoData() *MemoData {
+	return &MemoData{
+		SchemaVersion: currentSchemaVersion,
+		Notebooks:     map[string]*Notebook{defaultNotebook: newNotebook()},
+	}
+}
 
-	memos       []Memo
-	deleted     []Memo
-	currentMode ViewMode
-	currentMemo *Memo
+// Notebook returns the named notebook, creating it if it doesn't exist yet.
+func (d *MemoData) Notebook(name string) *Notebook {
+	if d.Notebooks == nil {
+		d.Notebooks = make(map[string]*Notebook)
+	}
+	nb, ok := d.Notebooks[name]
+	if !ok {
+		nb = newNotebook()
+		d.Notebooks[name] = nb
+	}
+	return nb
+}
 
-	flags uint8
+// Select returns the memos of the named notebook, or of all notebooks, in
+// name order, when name is empty.
+func (d *MemoData) Select(name string) Notebook {
+	if name != "" {
+		return *d.Notebook(name)
+	}
+	var all Notebook
+	for _, name := range notebookNames(d.Notebooks) {
+		nb := d.Notebooks[name]
+		all.Active = append(all.Active, nb.Active...)
+		all.Deleted = append(all.Deleted, nb.Deleted...)
+		all.Archived = append(all.Archived, nb.Archived...)
+	}
+	return all
+}
 
-	savedFilterValue string
-	width, height    int
+// clone copies the memo slices of every notebook, so the copy can be saved
+// while the original keeps changing.
+func (d *MemoData) clone() *MemoData {
+	c := &MemoData{SchemaVersion: d.SchemaVersion, Notebooks: make(map[string]*Notebook, len(d.Notebooks))}
+	for name, nb := range d.Notebooks {
+		c.Notebooks[name] = &Notebook{
+			Active:   slices.Clone(nb.Active),
+			Deleted:  slices.Clone(nb.Deleted),
+			Archived: slices.Clone(nb.Archived),
+		}
+	}
+	return c
 }
 
-const (
-	flagIsNewMemo   uint8 = 1 << 0
-	flagWasFiltered uint8 = 1 << 1
-)
+// sameMemos reports whether d and o hold the same memos in every notebook,
+// in any order. A missing notebook counts as an empty one.
+func (d *MemoData) sameMemos(o *MemoData) bool {
+	for _, pair := range [][2]*MemoData{{d, o}, {o, d}} {
+		for name, nb := range pair[0].Notebooks {
+			other, ok := pair[1].Notebooks[name]
+			if !ok {
+				other = newNotebook()
+			}
+			if !sameMemoSet(nb.Active, other.Active) ||
+				!sameMemoSet(nb.Deleted, other.Deleted) ||
+				!sameMemoSet(nb.Archived, other.Archived) {
+				return false
+			}
+		}
+	}
+	return true
+}
 
-func (m *Model) setFlag(flag uint8)      { m.flags |= flag }
-func (m *Model) clearFlag(flag uint8)    { m.flags &^= flag }
-func (m *Model) hasFlag(flag uint8) bool { return m.flags&flag != 0 }
+func sameMemoSet(a, b []Memo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byID := make(map[string]Memo, len(a))
+	for _, memo := range a {
+		byID[memo.ID] = memo
+	}
+	for _, memo := range b {
+		if other, ok := byID[memo.ID]; !ok || !memo.equal(other) {
+			return false
+		}
+	}
+	return true
+}
 
-func InitialModel() Model {
-	dataPath, err := getDataFilePath("yellow.json")
-	if err != nil {
-		log.Printf("Error getting data path: %v, falling back to current directory", err)
-		dataPath = ".yellow.json"
+// notebookNames lists the notebooks in alphabetical order.
+func notebookNames(notebooks map[string]*Notebook) []string {
+	names := make([]string, 0, len(notebooks))
+	for name := range notebooks {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	return Model{
-		list:        newList(make([]list.Item, 0, 32)),
-		textarea:    newTextarea(),
-		storage:     NewStorage(dataPath),
-		memos:       make([]Memo, 0, 32),
-		deleted:     make([]Memo, 0, 8),
-		currentMode: ViewModeList,
+// migrate upgrades data written by older versions to currentSchemaVersion,
+// one version at a time, and reports whether anything changed.
+func migrate(data *MemoData) (bool, error) {
+	if data.SchemaVersion > currentSchemaVersion {
+		return false, fmt.Errorf("memo file has schema version %d, newer than supported version %d",
+			data.SchemaVersion, currentSchemaVersion)
+	}
+
+	changed := false
+	for data.SchemaVersion < currentSchemaVersion {
+		switch data.SchemaVersion {
+		case 0:
+			// Unversioned files, including the bare-array format, may lack
+			// either list.
+			if data.LegacyActive == nil {
+				data.LegacyActive = make([]Memo, 0, 16)
+			}
+			if data.LegacyDeleted == nil {
+				data.LegacyDeleted = make([]Memo, 0, 8)
+			}
+		case 1:
+			// Version 2 added archived memos.
+			if data.LegacyArchived == nil {
+				data.LegacyArchived = make([]Memo, 0, 8)
+			}
+		case 2:
+			// Version 3 keeps memos in named notebooks.
+			nb := data.Notebook(defaultNotebook)
+			if data.LegacyActive != nil {
+				nb.Active = data.LegacyActive
+			}
+			if data.LegacyDeleted != nil {
+				nb.Deleted = data.LegacyDeleted
+			}
+			if data.LegacyArchived != nil {
+				nb.Archived = data.LegacyArchived
+			}
+			data.LegacyActive, data.LegacyDeleted, data.LegacyArchived = nil, nil, nil
+		}
+		data.SchemaVersion++
+		changed = true
 	}
+	return changed, nil
 }
 
-func (m Model) Init() tea.Cmd {
-	return loadMemos(m.storage)
+// Data Persistence ------------------------------------------------------------
+
+// Backend loads and saves memos. Storage, which keeps them in a JSON file, is
+// the default; SQLiteStorage keeps them in a database and MemoryStorage in
+// memory.
+type Backend interface {
+	Load() (*MemoData, error)
+	Save(data *MemoData) error
 }
 
-// Update ----------------------------------------------------------------------
+// fileBackend is a Backend kept on disk, which one instance at a time may
+// write to. Storage and SQLiteStorage are both.
+type fileBackend interface {
+	Backend
+	Lock() error
+	Unlock() error
+	SetReadOnly(readOnly bool)
+}
 
-type loadMemosMsg struct {
+// MemoryStorage is a Backend that keeps memos in memory, for trying the model
+// out without touching the file system.
+type MemoryStorage struct {
+	mu   sync.Mutex
 	data *MemoData
-	err  error
 }
 
-type saveCompleteMsg struct{ err error }
+func (s *MemoryStorage) Load() (*MemoData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return newMemoData(), nil
+	}
+	return s.data.clone(), nil
+}
 
-func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case loadMemosMsg:
-		if msg.err != nil {
-			log.Printf("Error loading: %v", msg.err)
-			return m, nil
-		}
-		m.memos = msg.data.Active
-		m.deleted = msg.data.Deleted
-		sortMemosNewestFirst(m.memos)
-		m.list.SetItems(memosToItems(m.memos))
-		return m, nil
+func (s *MemoryStorage) Save(data *MemoData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data.clone()
+	return nil
+}
 
-	case saveCompleteMsg:
-		if msg.err != nil {
-			log.Printf("Error saving: %v", msg.err)
-		}
-		return m, nil
+type Storage struct {
+	filepath string
 
-	case tea.WindowSizeMsg:
-		m.width, m.height = msg.Width, msg.Height
-		m.resizeComponents()
-		return m, nil
+	// retention is how long deleted memos are kept. Zero keeps them forever.
+	retention time.Duration
 
-	case tea.KeyMsg:
-		if m.currentMode == ViewModeList {
-			return m.handleListKeys(msg)
-		}
-		return m.handleEditKeys(msg)
-	}
+	mu sync.Mutex
 
-	return m.updateActiveComponent(msg)
+	// backupInterval is how often the memo file is copied aside before a
+	// save overwrites it, keeping the newest backupKeep copies. Zero turns
+	// backups off.
+	backupInterval time.Duration
+	backupKeep     int
+
+	// lastSeen describes the memo file as yellow last read or wrote it.
+	lastSeen atomic.Pointer[fileStamp]
+
+	lock *os.File
+
+	// readOnly keeps Load from writing back what it migrated, purged or is
+	// to encrypt, for commands that run without the lock.
+	readOnly bool
+
+	// passphrase turns on encryption when set. key caches the cipher
+	// derived from it, since derivation is deliberately slow.
+	passphrase string
+	keyMu      sync.Mutex
+	key        *fileKey
 }
 
-func (m Model) handleListKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	filterState := m.list.FilterState()
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
 
-	if filterState == list.Filtering {
-		var cmd tea.Cmd
-		m.list, cmd = m.list.Update(msg)
-		if msg.String() == "esc" {
-			m.list.ResetFilter()
+// ErrLocked is returned by Lock when another instance holds the memo file.
+var ErrLocked = errors.New("memo file is in use by another instance")
+
+func NewStorage(filepath string, retention time.Duration) *Storage {
+	return &Storage{filepath: filepath, retention: retention}
+}
+
+func (s *Storage) Load() (*MemoData, error) {
+	raw, err := os.ReadFile(s.filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newMemoData(), nil
 		}
-		return m, cmd
+		return nil, err
 	}
+	s.stamp()
 
-	if filterState == list.FilterApplied {
-		if msg.String() == "esc" {
-			m.list.ResetFilter()
-			return m, nil
-		}
-		if msg.String() == "enter" && len(m.memos) > 0 {
-			return m.editSelected()
+	data := raw
+	if isEncrypted(raw) {
+		if data, err = s.decrypt(raw); err != nil {
+			return nil, err
 		}
-		var cmd tea.Cmd
-		m.list, cmd = m.list.Update(msg)
-		return m, cmd
 	}
 
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "tab":
-		return m.createNew()
-	case "delete", "backspace":
-		if len(m.memos) > 0 {
-			return m.deleteSelected()
-		}
-	case "enter":
-		if len(m.memos) > 0 {
-			return m.editSelected()
+	var memoData MemoData
+	if err := json.Unmarshal(data, &memoData); err != nil {
+		// Files from before MemoData hold a bare array of memos.
+		var memos []Memo
+		if jsonErr := json.Unmarshal(data, &memos); jsonErr != nil {
+			return nil, s.quarantine(raw, err)
 		}
+		memoData = MemoData{LegacyActive: memos}
 	}
 
-	var cmd tea.Cmd
-	m.list, cmd = m.list.Update(msg)
-	return m, cmd
-}
+	changed, err := migrate(&memoData)
+	if err != nil {
+		return nil, err
+	}
+	// A passphrase given for a plain file encrypts it right away.
+	if s.passphrase != "" && !isEncrypted(raw) {
+		changed = true
+	}
+	if purgeDeleted(&memoData, s.retention) {
+		changed = true
+	}
 
-func (m Model) handleEditKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
-		return m.saveAndExit()
-	case "ctrl+c":
-		return m, tea.Quit
+	// Save before handing the data out, so this can't race with (and
+	// overwrite) saves made from the returned data.
+	if changed && !s.readOnly {
+		if err := s.Save(&memoData); err != nil {
+			log.Printf("Warning: failed to save migrated memo file: %v", err)
+		}
 	}
 
-	var cmd tea.Cmd
-	m.textarea, cmd = m.textarea.Update(msg)
-	return m, cmd
+	return &memoData, nil
 }
 
-func (m Model) updateActiveComponent(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
-	if m.currentMode == ViewModeList {
-		m.list, cmd = m.list.Update(msg)
-	} else {
-		m.textarea, cmd = m.textarea.Update(msg)
+// CorruptFileError is returned by Load when the memo file can't be parsed. The
+// file itself is left alone; Backup holds a copy of it.
+type CorruptFileError struct {
+	Backup string
+	Err    error
+}
+
+func (e *CorruptFileError) Error() string {
+	if e.Backup == "" {
+		return fmt.Sprintf("memo file is corrupt: %v", e.Err)
 	}
-	return m, cmd
+	return fmt.Sprintf("memo file is corrupt, a copy was saved to %s: %v", e.Backup, e.Err)
 }
 
-// Update Commands -------------------------------------------------------------
+func (e *CorruptFileError) Unwrap() error { return e.Err }
 
-func (m Model) createNew() (tea.Model, tea.Cmd) {
-	m.saveFilterState()
-	m.currentMemo = &Memo{
-		ID:        generateID(),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// quarantine copies an unparsable memo file to a timestamped ".bak" file, so
+// its contents survive the next save. Loading the same file again, as reloads
+// do, reuses the copy already made.
+func (s *Storage) quarantine(data []byte, parseErr error) error {
+	if backup, ok := s.findQuarantined(data); ok {
+		return &CorruptFileError{Backup: backup, Err: parseErr}
 	}
-	m.setFlag(flagIsNewMemo)
-	m.currentMode = ViewModeEdit
-	m.textarea.SetValue("")
-	m.textarea.Focus()
-	m.resizeComponents()
-	return m, textarea.Blink
+	backup := s.filepath + ".bak." + time.Now().Format("20060102-150405")
+	if err := os.WriteFile(backup, data, 0644); err != nil {
+		log.Printf("Warning: failed to back up corrupt memo file: %v", err)
+		backup = ""
+	}
+	return &CorruptFileError{Backup: backup, Err: parseErr}
 }
 
-func (m Model) editSelected() (tea.Model, tea.Cmd) {
-	if item := m.list.SelectedItem(); item != nil {
-		m.saveFilterState()
-		memo := item.(Memo)
-		m.currentMemo = &memo
-		m.clearFlag(flagIsNewMemo)
-		m.currentMode = ViewModeEdit
-		m.textarea.SetValue(memo.Content)
-		m.textarea.Focus()
-		m.resizeComponents()
-		return m, textarea.Blink
+// findQuarantined returns the ".bak" copy holding exactly data, if any.
+func (s *Storage) findQuarantined(data []byte) (string, bool) {
+	backups, err := filepath.Glob(s.filepath + ".bak.*")
+	if err != nil {
+		return "", false
 	}
-	return m, nil
+	for _, backup := range backups {
+		if info, err := os.Stat(backup); err != nil || info.Size() != int64(len(data)) {
+			continue
+		}
+		if existing, err := os.ReadFile(backup); err == nil && bytes.Equal(existing, data) {
+			return backup, true
+		}
+	}
+	return "", false
 }
 
-func (m Model) deleteSelected() (tea.Model, tea.Cmd) {
-	item := m.list.SelectedItem()
-	if item == nil {
-		return m, nil
+// purgeDeleted drops deleted memos older than the retention period and
+// reports whether any were dropped.
+func purgeDeleted(data *MemoData, retention time.Duration) bool {
+	if retention <= 0 {
+		return false
 	}
 
-	memo := item.(Memo)
-	for i := range m.memos {
-		if m.memos[i].ID == memo.ID {
-			now := time.Now()
-			memo.DeletedAt = &now
-			m.deleted = append(m.deleted, memo)
-			// Efficient slice deletion
-			m.memos = append(m.memos[:i], m.memos[i+1:]...)
-			break
+	cutoff := time.Now().Add(-retention)
+	purged := false
+	for _, nb := range data.Notebooks {
+		n := 0
+		for i := range nb.Deleted {
+			if nb.Deleted[i].DeletedAt != nil && nb.Deleted[i].DeletedAt.After(cutoff) {
+				nb.Deleted[n] = nb.Deleted[i]
+				n++
+			}
+		}
+		if n != len(nb.Deleted) {
+			nb.Deleted = nb.Deleted[:n]
+			purged = true
 		}
 	}
+	return purged
+}
 
-	sortMemosNewestFirst(m.memos)
-	m.list.SetItems(memosToItems(m.memos))
-	return m, saveMemos(m.storage, &MemoData{
-		Active:  m.memos,
-		Deleted: m.deleted,
-	})
+// Save replaces the memo file with writeFileAtomic, so a crash mid-write
+// leaves either the old memo file or the new one. Concurrent saves are
+// serialized.
+func (s *Storage) Save(data *MemoData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(data)
 }
 
-func (m Model) saveAndExit() (tea.Model, tea.Cmd) {
-	content := m.textarea.Value()
+// SetReadOnly keeps Load from writing the memo file. The changes Load would
+// have written are still made to the data it returns.
+func (s *Storage) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
 
-	if m.hasFlag(flagIsNewMemo) {
-		if strings.TrimSpace(content) != "" {
-			m.currentMemo.Content = content
-			m.currentMemo.UpdatedAt = time.Now()
+// SetBackups turns on rolling backups: before a save, the memo file is copied
+// to a ".<name>.backup.<time>.json" file next to it if the newest backup is
+// older than interval, and all but the newest keep backups are removed.
+func (s *Storage) SetBackups(interval time.Duration, keep int) {
+	s.backupInterval = interval
+	s.backupKeep = max(keep, 1)
+}
+
+// backupPattern matches the backups of the memo file. The timestamps in their
+// names sort in time order.
+func (s *Storage) backupPattern() string {
+	ext := filepath.Ext(s.filepath)
+	stem := strings.TrimSuffix(filepath.Base(s.filepath), ext)
+	return filepath.Join(filepath.Dir(s.filepath), "."+stem+".backup.*"+ext)
+}
+
+// backup copies the memo file aside if backups are on and due, and prunes
+// old backups. The caller holds s.mu.
+func (s *Storage) backup() error {
+	if s.backupInterval <= 0 {
+		return nil
+	}
+
+	pattern := s.backupPattern()
+	backups, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	if n := len(backups); n > 0 {
+		if info, err := os.Stat(backups[n-1]); err == nil && time.Since(info.ModTime()) < s.backupInterval {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(s.filepath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	name := strings.Replace(pattern, "*", time.Now().Format("20060102-150405"), 1)
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return err
+	}
+
+	backups = append(backups, name)
+	for _, old := range backups[:max(len(backups)-s.backupKeep, 0)] {
+		if err := os.Remove(old); err != nil {
+			log.Printf("Warning: failed to remove old backup: %v", err)
+		}
+	}
+	return nil
+}
+
+// Lock takes an advisory lock on a ".lock" file next to the memo file, so two
+// instances can't overwrite each other's memos. The OS drops the lock if the
+// process dies, so a leftover lock file never blocks a later start.
+func (s *Storage) Lock() error {
+	f, err := acquireLock(s.filepath + ".lock")
+	if err != nil {
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+func (s *Storage) Unlock() error {
+	err := releaseLock(s.lock)
+	s.lock = nil
+	return err
+}
+
+// acquireLock opens the lock file at path and locks it, returning ErrLocked
+// if another instance holds it.
+func acquireLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// releaseLock unlocks and closes a lock file from acquireLock, if any.
+func releaseLock(f *os.File) error {
+	if f == nil {
+		return nil
+	}
+	err := unlockFile(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *Storage) write(data *MemoData) error {
+	versioned := *data
+	versioned.SchemaVersion = currentSchemaVersion
+
+	jsonData, err := json.MarshalIndent(&versioned, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.passphrase != "" {
+		if jsonData, err = s.encrypt(jsonData); err != nil {
+			return err
+		}
+	}
+
+	if err := s.backup(); err != nil {
+		log.Printf("Warning: failed to back up memo file: %v", err)
+	}
+
+	if err := writeFileAtomic(s.filepath, jsonData, 0644); err != nil {
+		return err
+	}
+	s.stamp()
+	return nil
+}
+
+// writeFileAtomic writes data to a new temporary file next to path, syncs it
+// and renames it into place, then syncs the directory so the rename survives
+// a crash too. Readers see either the old file or the new one, never part of
+// it, and each writer gets its own temporary file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Not every platform can sync a directory, and the file itself is
+	// already safe, so errors here are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func (s *Storage) stamp() {
+	if info, err := os.Stat(s.filepath); err == nil {
+		s.lastSeen.Store(&fileStamp{info.ModTime(), info.Size()})
+	}
+}
+
+// ChangedOnDisk reports whether the memo file was changed by someone else
+// since yellow last read or wrote it.
+func (s *Storage) ChangedOnDisk() bool {
+	info, err := os.Stat(s.filepath)
+	if err != nil {
+		return false
+	}
+	last := s.lastSeen.Load()
+	return last == nil || !info.ModTime().Equal(last.modTime) || info.Size() != last.size
+}
+
+// Saver versions the snapshots handed to a Backend, so that saves running in
+// the background can't overwrite newer memos with older ones.
+type Saver struct {
+	backend Backend
+
+	mu           sync.Mutex
+	version      atomic.Uint64
+	savedVersion uint64
+
+	// pending is the newest snapshot handed to saveMemos, kept until it is
+	// written so that Flush can write it if the program quits first.
+	pending        *MemoData
+	pendingVersion uint64
+
+	// stored is what the backend holds as far as the Saver knows, so that
+	// saving the same memos again can be skipped.
+	stored *MemoData
+}
+
+func NewSaver(backend Backend) *Saver {
+	return &Saver{backend: backend}
+}
+
+// Load loads memos from the backend and remembers them as stored. Snapshots
+// waiting to be saved were taken from the memos before this load, so they are
+// dropped rather than written over what was just loaded.
+func (s *Saver) Load() (*MemoData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.backend.Load()
+	if err != nil {
+		return nil, err
+	}
+	s.stored = data.clone()
+	s.dropPending()
+	return data, nil
+}
+
+// DropPending drops the snapshots not yet written, for a model that has just
+// replaced its memos with reloaded ones.
+func (s *Saver) DropPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropPending()
+}
+
+// dropPending marks every version handed out so far as written. The caller
+// holds s.mu.
+func (s *Saver) dropPending() {
+	s.savedVersion = max(s.savedVersion, s.version.Load())
+	s.pending = nil
+}
+
+// nextVersion reserves a version number for data about to be saved, and
+// remembers data as pending until it is written.
+func (s *Saver) nextVersion(data *MemoData) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := s.version.Add(1)
+	s.pending, s.pendingVersion = data, version
+	return version
+}
+
+// superseded reports whether a newer snapshot than version was handed out, or
+// version was dropped, so there is no point in writing it.
+func (s *Saver) superseded(version uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return version != s.version.Load() || version <= s.savedVersion
+}
+
+// Flush writes the pending snapshot, if it has not been written yet.
+func (s *Saver) Flush() error {
+	s.mu.Lock()
+	version, data := s.pendingVersion, s.pending
+	s.mu.Unlock()
+
+	if data == nil {
+		return nil
+	}
+	return s.saveVersion(version, data)
+}
+
+// saveVersion writes data unless a newer version has already been written,
+// so a slow save of an older snapshot can't overwrite newer memos. Data the
+// backend already holds is not written again.
+func (s *Saver) saveVersion(version uint64, data *MemoData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version <= s.savedVersion {
+		return nil
+	}
+	if s.stored == nil || !s.stored.sameMemos(data) {
+		if err := s.backend.Save(data); err != nil {
+			return err
+		}
+		s.stored = data
+	}
+	s.savedVersion = version
+	if s.pendingVersion <= version {
+		s.pending = nil
+	}
+	return nil
+}
+
+// SQLite Storage --------------------------------------------------------------
+
+// sqliteSchema creates the tables of a memo database. Each memo is a row, so
+// that a save only writes the memos that changed.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS notebooks (
+	name TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS memos (
+	notebook   TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	deleted_at TEXT,
+	archived   INTEGER NOT NULL DEFAULT 0,
+	starred    INTEGER NOT NULL DEFAULT 0,
+	daily      TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (notebook, id)
+);`
+
+// The state column says which list of its notebook a memo is in.
+const (
+	memoActive   = "active"
+	memoDeleted  = "deleted"
+	memoArchived = "archived"
+)
+
+// SQLiteStorage is a Backend that keeps memos in a SQLite database. The first
+// load imports the JSON memo file at importFrom, which is left as it was.
+type SQLiteStorage struct {
+	path       string
+	importFrom string
+
+	// retention is how long deleted memos are kept. Zero keeps them forever.
+	retention time.Duration
+
+	mu sync.Mutex
+	db *sql.DB
+
+	// stored and notebooks are what the database holds as of the last load
+	// or save, so that a save only writes what differs.
+	stored    map[memoKey]memoRow
+	notebooks map[string]struct{}
+
+	lock     *os.File
+	readOnly bool
+}
+
+type memoKey struct{ notebook, id string }
+
+type memoRow struct {
+	state string
+	memo  Memo
+}
+
+func NewSQLiteStorage(path, importFrom string, retention time.Duration) *SQLiteStorage {
+	return &SQLiteStorage{path: path, importFrom: importFrom, retention: retention}
+}
+
+// databasePaths returns the SQLite database to keep memos in, if --db was
+// given or the memo file ends in .db, and the JSON memo file to import into
+// it on first use.
+func databasePaths(db, dataPath string) (string, string) {
+	if db != "" {
+		return db, dataPath
+	}
+	if filepath.Ext(dataPath) == ".db" {
+		return dataPath, resolveDataPath("")
+	}
+	return "", ""
+}
+
+func (s *SQLiteStorage) Load() (*MemoData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Without the lock, a database that doesn't exist yet is not created;
+	// the memo file it would import is read instead.
+	if _, err := os.Stat(s.path); s.readOnly && os.IsNotExist(err) {
+		return s.readImport()
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		if s.readOnly {
+			return s.readImport()
+		}
+		if err := s.importJSON(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if purgeDeleted(data, s.retention) && !s.readOnly {
+		if err := s.write(data); err != nil {
+			log.Printf("Warning: failed to purge deleted memos: %v", err)
+		}
+	}
+	return data, nil
+}
+
+// Save writes the memos that changed since the last load or save, and
+// deletes those that are gone, in one transaction.
+func (s *SQLiteStorage) Save(data *MemoData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.stored == nil {
+		if _, err := s.read(); err != nil {
+			return err
+		}
+	}
+	return s.write(data)
+}
+
+// SetReadOnly keeps Load from creating the database, importing into it or
+// purging it. The memos Load returns are the same.
+func (s *SQLiteStorage) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// Lock takes the same kind of lock as Storage.Lock, on a ".lock" file next to
+// the database.
+func (s *SQLiteStorage) Lock() error {
+	f, err := acquireLock(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+func (s *SQLiteStorage) Unlock() error {
+	err := releaseLock(s.lock)
+	s.lock = nil
+	return err
+}
+
+func (s *SQLiteStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// open opens the database and creates its tables, if not done yet. The
+// caller holds s.mu.
+func (s *SQLiteStorage) open() error {
+	if s.db != nil {
+		return nil
+	}
+	db, err := sql.Open("sqlite", s.path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// readImport loads the JSON memo file the database imports, without writing
+// it.
+func (s *SQLiteStorage) readImport() (*MemoData, error) {
+	js := NewStorage(s.importFrom, s.retention)
+	js.SetReadOnly(true)
+	if encrypted, err := js.Encrypted(); err != nil {
+		return nil, err
+	} else if encrypted {
+		return nil, fmt.Errorf("%s is encrypted, which a SQLite database can't import", s.importFrom)
+	}
+	return js.Load()
+}
+
+// importJSON copies the JSON memo file into the database and marks the
+// database as imported, in one transaction. The caller holds s.mu.
+func (s *SQLiteStorage) importJSON() error {
+	data, err := s.readImport()
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", s.importFrom, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	s.stored, s.notebooks = nil, nil
+	stored, notebooks, err := s.update(tx, data)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("PRAGMA user_version = 1"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.stored, s.notebooks = stored, notebooks
+	log.Printf("Imported %d memos from %s into %s", len(stored), s.importFrom, s.path)
+	return nil
+}
+
+// read loads every memo from the database. The caller holds s.mu.
+func (s *SQLiteStorage) read() (*MemoData, error) {
+	data := newMemoData()
+	names, err := s.db.Query("SELECT name FROM notebooks")
+	if err != nil {
+		return nil, err
+	}
+	defer names.Close()
+	for names.Next() {
+		var name string
+		if err := names.Scan(&name); err != nil {
+			return nil, err
+		}
+		data.Notebook(name)
+	}
+	if err := names.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT notebook, id, state, content, created_at, updated_at, deleted_at, archived, starred, daily
+		FROM memos ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			notebook, state, created, updated string
+			deleted                           sql.NullString
+			memo                              Memo
+		)
+		if err := rows.Scan(&notebook, &memo.ID, &state, &memo.Content, &created, &updated,
+			&deleted, &memo.Archived, &memo.Starred, &memo.Daily); err != nil {
+			return nil, err
+		}
+		if memo.CreatedAt, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, err
+		}
+		if memo.UpdatedAt, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+			return nil, err
+		}
+		if deleted.Valid {
+			at, err := time.Parse(time.RFC3339Nano, deleted.String)
+			if err != nil {
+				return nil, err
+			}
+			memo.DeletedAt = &at
+		}
+
+		nb := data.Notebook(notebook)
+		switch state {
+		case memoDeleted:
+			nb.Deleted = append(nb.Deleted, memo)
+		case memoArchived:
+			nb.Archived = append(nb.Archived, memo)
+		default:
+			nb.Active = append(nb.Active, memo)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.stored, s.notebooks = memoRows(data), notebookSet(data)
+	return data, nil
+}
+
+// write saves data in a transaction. The caller holds s.mu.
+func (s *SQLiteStorage) write(data *MemoData) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, notebooks, err := s.update(tx, data)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.stored, s.notebooks = stored, notebooks
+	return nil
+}
+
+// update writes the memos and notebooks of data that differ from what the
+// database holds, and deletes those data no longer has. It returns what the
+// database holds once tx is committed.
+func (s *SQLiteStorage) update(tx *sql.Tx, data *MemoData) (map[memoKey]memoRow, map[string]struct{}, error) {
+	stored, notebooks := memoRows(data), notebookSet(data)
+
+	upsert, err := tx.Prepare(`INSERT OR REPLACE INTO memos
+		(notebook, id, state, content, created_at, updated_at, deleted_at, archived, starred, daily)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer upsert.Close()
+	for key, row := range stored {
+		if old, ok := s.stored[key]; ok && old.state == row.state && old.memo.equal(row.memo) {
+			continue
+		}
+		memo := row.memo
+		var deleted sql.NullString
+		if memo.DeletedAt != nil {
+			deleted = sql.NullString{String: memo.DeletedAt.Format(time.RFC3339Nano), Valid: true}
+		}
+		if _, err := upsert.Exec(key.notebook, key.id, row.state, memo.Content,
+			memo.CreatedAt.Format(time.RFC3339Nano), memo.UpdatedAt.Format(time.RFC3339Nano),
+			deleted, memo.Archived, memo.Starred, memo.Daily); err != nil {
+			return nil, nil, err
+		}
+	}
+	for key := range s.stored {
+		if _, ok := stored[key]; !ok {
+			if _, err := tx.Exec("DELETE FROM memos WHERE notebook = ? AND id = ?", key.notebook, key.id); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for name := range notebooks {
+		if _, ok := s.notebooks[name]; !ok {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO notebooks (name) VALUES (?)", name); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for name := range s.notebooks {
+		if _, ok := notebooks[name]; !ok {
+			if _, err := tx.Exec("DELETE FROM notebooks WHERE name = ?", name); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return stored, notebooks, nil
+}
+
+// memoRows keys every memo of data by notebook and ID.
+func memoRows(data *MemoData) map[memoKey]memoRow {
+	rows := make(map[memoKey]memoRow)
+	for name, nb := range data.Notebooks {
+		for _, list := range []struct {
+			state string
+			memos []Memo
+		}{{memoActive, nb.Active}, {memoDeleted, nb.Deleted}, {memoArchived, nb.Archived}} {
+			for _, memo := range list.memos {
+				rows[memoKey{name, memo.ID}] = memoRow{list.state, memo}
+			}
+		}
+	}
+	return rows
+}
+
+func notebookSet(data *MemoData) map[string]struct{} {
+	names := make(map[string]struct{}, len(data.Notebooks))
+	for name := range data.Notebooks {
+		names[name] = struct{}{}
+	}
+	return names
+}
+
+// Encryption ------------------------------------------------------------------
+
+// encryptedMagic starts every encrypted memo file. It is followed by the salt,
+// the nonce and the AES-GCM sealed JSON.
+var encryptedMagic = []byte("YELLOW-ENCRYPTED-1\n")
+
+const (
+	saltSize = 16
+
+	// kdfIterations follows the OWASP recommendation for PBKDF2-SHA256.
+	kdfIterations = 600_000
+)
+
+var (
+	ErrPassphraseRequired = errors.New("memo file is encrypted, set YELLOW_PASSPHRASE or run yellow in a terminal to enter the passphrase")
+	ErrWrongPassphrase    = errors.New("wrong passphrase for the memo file, or the file is damaged")
+)
+
+type fileKey struct {
+	salt []byte
+	aead cipher.AEAD
+}
+
+func deriveKey(passphrase string, salt []byte) (*fileKey, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &fileKey{salt: salt, aead: aead}, nil
+}
+
+func isEncrypted(data []byte) bool { return bytes.HasPrefix(data, encryptedMagic) }
+
+// SetPassphrase makes the storage encrypt what it saves and decrypt encrypted
+// memo files. Plain files still load, and are encrypted when next saved.
+func (s *Storage) SetPassphrase(passphrase string) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	s.passphrase = passphrase
+	s.key = nil
+}
+
+func (s *Storage) encrypt(plain []byte) ([]byte, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if s.key == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		key, err := deriveKey(s.passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+
+	nonce := make([]byte, s.key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := slices.Concat(encryptedMagic, s.key.salt, nonce)
+	return s.key.aead.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+func (s *Storage) decrypt(data []byte) ([]byte, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if s.passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < saltSize {
+		return nil, ErrWrongPassphrase
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+
+	key := s.key
+	if key == nil || !bytes.Equal(key.salt, salt) {
+		var err error
+		if key, err = deriveKey(s.passphrase, bytes.Clone(salt)); err != nil {
+			return nil, err
+		}
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrWrongPassphrase
+	}
+	plain, err := key.aead.Open(nil, data[:nonceSize], data[nonceSize:], encryptedMagic)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	// Keep the file's salt so saves don't pay for another derivation.
+	s.key = key
+	return plain, nil
+}
+
+// Encrypted reports whether the memo file on disk is encrypted.
+func (s *Storage) Encrypted() (bool, error) {
+	data, err := os.ReadFile(s.filepath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return isEncrypted(data), err
+}
+
+// CheckPassphrase decrypts the memo file, if it is encrypted, to make sure
+// the passphrase is right before anything else touches it.
+func (s *Storage) CheckPassphrase() error {
+	data, err := os.ReadFile(s.filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !isEncrypted(data) {
+		return nil
+	}
+	_, err = s.decrypt(data)
+	return err
+}
+
+// setupEncryption takes the passphrase from YELLOW_PASSPHRASE, or asks for
+// one when the memo file is encrypted or encryption is being turned on.
+func setupEncryption(s *Storage, encrypt bool) error {
+	encrypted, err := s.Encrypted()
+	if err != nil {
+		return err
+	}
+
+	passphrase := os.Getenv("YELLOW_PASSPHRASE")
+	if passphrase == "" && (encrypted || encrypt) {
+		if passphrase, err = promptPassphrase("Passphrase: "); err != nil {
+			return err
+		}
+		if !encrypted {
+			confirm, err := promptPassphrase("Repeat passphrase: ")
+			if err != nil {
+				return err
+			}
+			if confirm != passphrase {
+				return errors.New("passphrases don't match")
+			}
+		}
+	}
+	if passphrase == "" {
+		if encrypt {
+			return errors.New("encryption needs a passphrase")
+		}
+		return nil
+	}
+
+	s.SetPassphrase(passphrase)
+	return s.CheckPassphrase()
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	if !term.IsTerminal(fd) {
+		return "", ErrPassphraseRequired
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(passphrase), err
+}
+
+// File Watching ---------------------------------------------------------------
+
+type fileChangedMsg struct{}
+
+const watchDebounce = 250 * time.Millisecond
+
+// watchStorage sends a fileChangedMsg when the memo file changes on disk,
+// coalescing bursts of events into a single message. The directory is watched
+// rather than the file, since saves replace the file by renaming.
+func watchStorage(s *Storage, send func(tea.Msg)) (stop func() error, err error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(filepath.Dir(s.filepath)); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	name := filepath.Clean(s.filepath)
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != name || !event.Op.Has(fsnotify.Write) && !event.Op.Has(fsnotify.Create) {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(watchDebounce, func() { send(fileChangedMsg{}) })
+				} else {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Warning: file watcher error: %v", err)
+			}
+		}
+	}()
+
+	return w.Close, nil
+}
+
+// Notifications ---------------------------------------------------------------
+
+// notify shows a desktop notification with notify-send on Linux and the BSDs,
+// osascript on macOS and a PowerShell toast on Windows.
+func notify(title, body string) error {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(body), strconv.Quote(title))
+		c = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName("text")
+$text.Item(0).AppendChild($xml.CreateTextNode(%s)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode(%s)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier("yellow").Show([Windows.UI.Notifications.ToastNotification]::new($xml))`,
+			psQuote(title), psQuote(body))
+		c = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		c = exec.Command("notify-send", "--app-name=yellow", title, body)
+	}
+	return c.Run()
+}
+
+// psQuote quotes s as a single-quoted PowerShell string.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// notifyCmd sends a desktop notification in the background. Failures are
+// only logged, since there is nowhere else to report them.
+func notifyCmd(title, body string) tea.Cmd {
+	return func() tea.Msg {
+		if err := notify(title, body); err != nil {
+			log.Printf("Warning: desktop notification failed: %v", err)
+		}
+		return nil
+	}
+}
+
+// Import & Export -------------------------------------------------------------
+
+// ExportMarkdown writes memos as Markdown sections, oldest first.
+func ExportMarkdown(w io.Writer, memos []Memo) error {
+	sorted := make([]Memo, len(memos))
+	copy(sorted, memos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Yellow")
+	for _, memo := range sorted {
+		fmt.Fprintf(bw, "\n## %s\n\n", memo.Title())
+		fmt.Fprintf(bw, "- Created: %s\n", memo.CreatedAt.Format(timeFormat))
+		fmt.Fprintf(bw, "- Updated: %s\n", memo.UpdatedAt.Format(timeFormat))
+		if memo.DeletedAt != nil {
+			fmt.Fprintf(bw, "- Deleted: %s\n", memo.DeletedAt.Format(timeFormat))
+		}
+		fmt.Fprintf(bw, "\n%s\n", strings.TrimRight(memo.Content, "\n"))
+	}
+	return bw.Flush()
+}
+
+// exportToFile exports the memos of a notebook, or of all notebooks when
+// notebook is empty.
+func exportToFile(s Backend, notebook, path string, includeDeleted bool) error {
+	data, err := s.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load memos: %w", err)
+	}
+
+	nb := data.Select(notebook)
+	memos := nb.Active
+	if includeDeleted {
+		memos = append(memos, nb.Deleted...)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if err := ExportMarkdown(f, memos); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return f.Close()
+}
+
+// slugify turns a memo title into a safe file name stem, dropping anything
+// that isn't a letter or digit.
+func slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" || slug == "empty-memo" {
+		return "memo"
+	}
+	return slug
+}
+
+// createNewFile creates a file in dir named slug plus ext, adding a number if
+// the name is taken.
+func createNewFile(dir, slug, ext string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := slug + ext
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d%s", slug, i, ext)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+}
+
+// writeMemoFile writes the memo content to a file in dir named after its
+// title, and returns the path.
+func writeMemoFile(dir string, memo Memo) (string, error) {
+	f, err := createNewFile(dir, slugify(memo.Title()), ".md")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(memo.Content); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// writeFilteredFile writes memos as JSON, the way list --json prints them, to
+// a file in dir named after the filter term, and returns the path.
+func writeFilteredFile(dir, term string, memos []Memo) (string, error) {
+	f, err := createNewFile(dir, slugify(term), ".json")
+	if err != nil {
+		return "", err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(memos); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// printMemo writes the content of the memo with the given ID to w.
+func printMemo(s Backend, id string, w io.Writer) error {
+	data, err := s.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load memos: %w", err)
+	}
+
+	all := data.Select("")
+	for _, memo := range append(all.Active, all.Deleted...) {
+		if memo.ID == id {
+			_, err := fmt.Fprintln(w, memo.Content)
+			return err
+		}
+	}
+	return fmt.Errorf("no memo with id %q", id)
+}
+
+// ParseImport splits text into memos on lines consisting of "---", skipping
+// chunks that are empty.
+func ParseImport(r io.Reader) ([]Memo, error) {
+	memos := make([]Memo, 0, 16)
+	var chunk strings.Builder
+
+	flush := func() {
+		content := strings.Trim(chunk.String(), "\n")
+		chunk.Reset()
+		if strings.TrimSpace(content) == "" {
+			return
+		}
+		now := time.Now()
+		memos = append(memos, Memo{
+			ID:        generateID(),
+			Content:   content,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "---" {
+			flush()
+			continue
+		}
+		chunk.WriteString(line)
+		chunk.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return memos, nil
+}
+
+func importFromFile(s Backend, notebook, path string, charLimit int) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	imported, err := ParseImport(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read import file: %w", err)
+	}
+	warnOverLimit(imported, charLimit)
+
+	data, err := s.Load()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load memos: %w", err)
+	}
+	nb := data.Notebook(notebook)
+	nb.Active = append(nb.Active, imported...)
+	if err := s.Save(data); err != nil {
+		return 0, fmt.Errorf("failed to save memos: %w", err)
+	}
+	return len(imported), nil
+}
+
+// Commands --------------------------------------------------------------------
+
+// runAdd appends a memo made of args, or of stdin when there are no args, to
+// the given notebook.
+func runAdd(s Backend, notebook string, args []string, stdin io.Reader) error {
+	content := strings.Join(args, " ")
+	if len(args) == 0 {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		content = string(data)
+	}
+	content = strings.TrimRight(content, "\n")
+	if strings.TrimSpace(content) == "" {
+		return errors.New("nothing to add")
+	}
+
+	data, err := s.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load memos: %w", err)
+	}
+
+	now := time.Now()
+	nb := data.Notebook(notebook)
+	nb.Active = append(nb.Active, Memo{
+		ID:        generateID(),
+		Content:   content,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err := s.Save(data); err != nil {
+		return fmt.Errorf("failed to save memos: %w", err)
+	}
+	return nil
+}
+
+// runList prints the active memos of a notebook, or of all notebooks when
+// notebook is empty, newest first, as tab-separated id, title and update
+// time, or as JSON with --json.
+func runList(s Backend, notebook string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "print memos as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := s.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load memos: %w", err)
+	}
+	memos := data.Select(notebook).Active
+	if memos == nil {
+		memos = []Memo{}
+	}
+	sortMemosNewestFirst(memos)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(memos)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, memo := range memos {
+		fmt.Fprintf(bw, "%s\t%s\t%s\n", memo.ID, memo.Title(), memo.UpdatedAt.Format(timeFormat))
+	}
+	return bw.Flush()
+}
+
+// Stats summarizes the memo file for `yellow stats`. Word, character, date
+// and tag figures cover active memos only.
+type Stats struct {
+	Active   int        `json:"active"`
+	Deleted  int        `json:"deleted"`
+	Archived int        `json:"archived"`
+	Words    int        `json:"words"`
+	Chars    int        `json:"chars"`
+	AvgWords float64    `json:"avg_words"`
+	AvgChars float64    `json:"avg_chars"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	Newest   *time.Time `json:"newest,omitempty"`
+	TopTags  []TagCount `json:"top_tags"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// maxStatsTags is how many of the most used tags `yellow stats` lists.
+const maxStatsTags = 5
+
+func computeStats(data Notebook) Stats {
+	st := Stats{
+		Active:   len(data.Active),
+		Deleted:  len(data.Deleted),
+		Archived: len(data.Archived),
+		TopTags:  make([]TagCount, 0, maxStatsTags),
+	}
+
+	for i := range data.Active {
+		memo := &data.Active[i]
+		st.Words += countWords(memo.Content)
+		st.Chars += utf8.RuneCountInString(memo.Content)
+		if st.Oldest == nil || memo.CreatedAt.Before(*st.Oldest) {
+			st.Oldest = &memo.CreatedAt
+		}
+		if st.Newest == nil || memo.CreatedAt.After(*st.Newest) {
+			st.Newest = &memo.CreatedAt
+		}
+	}
+	if st.Active > 0 {
+		st.AvgWords = float64(st.Words) / float64(st.Active)
+		st.AvgChars = float64(st.Chars) / float64(st.Active)
+	}
+
+	for _, tag := range countTags(data.Active) {
+		if len(st.TopTags) == maxStatsTags {
+			break
+		}
+		st.TopTags = append(st.TopTags, TagCount{tag.name, tag.count})
+	}
+	return st
+}
+
+// runStats prints totals about the memos of a notebook, or of all notebooks
+// when notebook is empty, as plain text, or as JSON with --json.
+func runStats(s Backend, notebook string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "print stats as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := s.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load memos: %w", err)
+	}
+	st := computeStats(data.Select(notebook))
+
+	if *jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Memos:     %d active, %d in trash, %d archived\n", st.Active, st.Deleted, st.Archived)
+	fmt.Fprintf(bw, "Words:     %d (%.1f per memo)\n", st.Words, st.AvgWords)
+	fmt.Fprintf(bw, "Chars:     %d (%.1f per memo)\n", st.Chars, st.AvgChars)
+	if st.Oldest != nil {
+		fmt.Fprintf(bw, "Oldest:    %s\n", st.Oldest.Format(timeFormat))
+		fmt.Fprintf(bw, "Newest:    %s\n", st.Newest.Format(timeFormat))
+	}
+	if len(st.TopTags) > 0 {
+		tags := make([]string, len(st.TopTags))
+		for i, tag := range st.TopTags {
+			tags[i] = fmt.Sprintf("#%s (%d)", tag.Tag, tag.Count)
+		}
+		fmt.Fprintf(bw, "Top tags:  %s\n", strings.Join(tags, ", "))
+	}
+	return bw.Flush()
+}
+
+// Config ----------------------------------------------------------------------
+
+type Config struct {
+	ConfirmDelete   bool `json:"confirm_delete"`
+	AutosaveSeconds int  `json:"autosave_seconds"`
+	CharLimit       int  `json:"char_limit"`
+	RetentionDays   int  `json:"trash_retention_days"`
+	ShowCreated     bool `json:"show_created"`
+	RelativeTime    bool `json:"relative_time"`
+	VimMode         bool `json:"vim_mode"`
+	KeepEmpty       bool `json:"keep_empty_memos"`
+	LineNumbers     bool `json:"line_numbers"`
+	Compact         bool `json:"compact"`
+	NotifyErrors    bool `json:"notify_on_error"`
+	WarnDuplicates  bool `json:"warn_duplicates"`
+	SaveDelayMs     int  `json:"save_delay_ms"`
+	MaxActive       int  `json:"max_active_memos"`
+	BackupHours     int  `json:"backup_interval_hours"`
+	BackupKeep      int  `json:"backup_keep"`
+	MutedAfterDays  int  `json:"muted_after_days"`
+	StaleAfterDays  int  `json:"stale_after_days"`
+	LogMaxMB        int  `json:"log_max_mb"`
+
+	// DateFormat is a Go time layout used when inserting the date, and
+	// TimeFormat one for showing timestamps.
+	DateFormat string `json:"date_format"`
+	TimeFormat string `json:"time_format"`
+
+	// StarMarker is shown in front of starred memos.
+	StarMarker string `json:"star_marker"`
+
+	// Templates maps template names to the content new memos made from
+	// them start with. See expandTemplate for the placeholders.
+	// The template named "daily" seeds daily notes.
+	Templates map[string]string `json:"templates"`
+
+	Keys KeyMap `json:"keys"`
+
+	path string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ConfirmDelete:   true,
+		AutosaveSeconds: 30,
+		RetentionDays:   7,
+		RelativeTime:    true,
+		LineNumbers:     true,
+		SaveDelayMs:     500,
+		BackupKeep:      7,
+		MutedAfterDays:  7,
+		StaleAfterDays:  30,
+		LogMaxMB:        5,
+		DateFormat:      "2006-01-02 15:04",
+		TimeFormat:      "2006-01-02 15:04:05",
+		StarMarker:      "★",
+		Keys:            DefaultKeyMap(),
+	}
+}
+
+// LoadConfig reads the config file at path. Missing keys keep their default
+// values, and a missing file yields the defaults.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	cfg.path = path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return DefaultConfig(), err
+	}
+	cfg.Keys = cfg.Keys.validate()
+	defaults := DefaultConfig()
+	for _, f := range []struct {
+		name     string
+		layout   *string
+		fallback string
+	}{
+		{"date_format", &cfg.DateFormat, defaults.DateFormat},
+		{"time_format", &cfg.TimeFormat, defaults.TimeFormat},
+	} {
+		if !isValidLayout(*f.layout) {
+			log.Printf("Warning: invalid %s %q, using %q", f.name, *f.layout, f.fallback)
+			*f.layout = f.fallback
+		}
+	}
+	if cfg.StarMarker == "" || string(singleLine([]rune(cfg.StarMarker))) != cfg.StarMarker {
+		log.Printf("Warning: invalid star_marker %q, using %q", cfg.StarMarker, defaults.StarMarker)
+		cfg.StarMarker = defaults.StarMarker
+	}
+	return cfg, nil
+}
+
+// isValidLayout reports whether layout is a Go time layout, that is whether
+// formatting a time with it shows anything of that time.
+func isValidLayout(layout string) bool {
+	return layout != "" && time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout) != layout
+}
+
+func (c Config) Retention() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
+
+// BackupInterval is how often the memo file is backed up, or zero for never.
+func (c Config) BackupInterval() time.Duration {
+	return time.Duration(c.BackupHours) * time.Hour
+}
+
+// SaveDelay is how long changes are collected before they are written.
+func (c Config) SaveDelay() time.Duration {
+	return time.Duration(c.SaveDelayMs) * time.Millisecond
+}
+
+// MutedAfter and StaleAfter are how long a memo goes without an update before
+// it is shown as muted or stale, or zero for never.
+func (c Config) MutedAfter() time.Duration {
+	return time.Duration(c.MutedAfterDays) * 24 * time.Hour
+}
+
+func (c Config) StaleAfter() time.Duration {
+	return time.Duration(c.StaleAfterDays) * 24 * time.Hour
+}
+
+// LogMaxSize is the size in bytes past which the log is rotated, or zero
+// for never.
+func (c Config) LogMaxSize() int64 {
+	return int64(c.LogMaxMB) << 20
+}
+
+// SaveSetting sets one option in the file the config was loaded from, so
+// settings toggled from the UI survive restarts. The rest of the file is kept
+// as written: options left out stay out, and unknown ones aren't dropped.
+func (c Config) SaveSetting(name string, value any) error {
+	if c.path == "" {
+		return nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+	}
+	if fields[name], err = json.Marshal(value); err != nil {
+		return err
+	}
+
+	if data, err = json.MarshalIndent(fields, "", "  "); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, append(data, '\n'), 0644)
+}
+
+// Keys lists the keys bound to an action, as bubbletea names them ("tab",
+// "ctrl+r", "q"). "space" stands for the space bar.
+type Keys []string
+
+func (k Keys) Matches(msg tea.KeyMsg) bool {
+	key := msg.String()
+	if key == " " {
+		key = "space"
+	}
+	return slices.Contains(k, key)
+}
+
+// String returns the first key for help text, capitalizing named keys like
+// "Tab" the way the help line always has.
+func (k Keys) String() string {
+	if len(k) == 0 {
+		return ""
+	}
+	switch k[0] {
+	case "tab", "enter", "delete", "backspace", "esc":
+		return strings.ToUpper(k[0][:1]) + k[0][1:]
+	}
+	return k[0]
+}
+
+// All returns every key, for the full key bindings.
+func (k Keys) All() string {
+	return strings.Join(k, "/")
+}
+
+// KeyMap binds actions to keys. Ctrl+c always quits and can't be rebound.
+type KeyMap struct {
+	Quit      Keys `json:"quit"`
+	New       Keys `json:"new"`
+	Edit      Keys `json:"edit"`
+	Delete    Keys `json:"delete"`
+	Mark      Keys `json:"mark"`
+	Top       Keys `json:"top"`
+	Bottom    Keys `json:"bottom"`
+	Undo      Keys `json:"undo"`
+	Copy      Keys `json:"copy"`
+	Editor    Keys `json:"external_editor"`
+	Duplicate Keys `json:"duplicate"`
+	Write     Keys `json:"write"`
+	Archive   Keys `json:"archive"`
+	Archived  Keys `json:"show_archive"`
+	Trash     Keys `json:"show_trash"`
+	Tags      Keys `json:"show_tags"`
+	Sort      Keys `json:"sort"`
+	Timestamp Keys `json:"toggle_timestamp"`
+	Preview   Keys `json:"toggle_preview"`
+	Reload    Keys `json:"reload"`
+	Replace   Keys `json:"replace"`
+	Star      Keys `json:"star"`
+	Starred   Keys `json:"show_starred"`
+	Due       Keys `json:"show_due"`
+	Compact   Keys `json:"toggle_compact"`
+	Notebook  Keys `json:"switch_notebook"`
+	Info      Keys `json:"show_info"`
+	Merge     Keys `json:"merge"`
+	Read      Keys `json:"read"`
+	DateRange Keys `json:"date_range"`
+	Export    Keys `json:"export_filtered"`
+	Focus     Keys `json:"focus_preview"`
+	Template  Keys `json:"new_from_template"`
+	Daily     Keys `json:"daily"`
+	Help      Keys `json:"help"`
+
+	// Save, LineNumbers, Clear and Date are used in the editor, where the
+	// list bindings would be typed.
+	Save        Keys `json:"save"`
+	LineNumbers Keys `json:"toggle_line_numbers"`
+	Clear       Keys `json:"clear"`
+	Date        Keys `json:"insert_date"`
+}
+
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Quit:        Keys{"q"},
+		New:         Keys{"tab"},
+		Edit:        Keys{"enter"},
+		Delete:      Keys{"delete", "backspace"},
+		Mark:        Keys{"space"},
+		Top:         Keys{"g", "home"},
+		Bottom:      Keys{"G", "end"},
+		Undo:        Keys{"u"},
+		Copy:        Keys{"y"},
+		Editor:      Keys{"E"},
+		Duplicate:   Keys{"D"},
+		Write:       Keys{"w"},
+		Archive:     Keys{"a"},
+		Archived:    Keys{"A"},
+		Trash:       Keys{"t"},
+		Tags:        Keys{"#"},
+		Sort:        Keys{"s"},
+		Timestamp:   Keys{"i"},
+		Preview:     Keys{"p"},
+		Reload:      Keys{"ctrl+r"},
+		Replace:     Keys{"R"},
+		Star:        Keys{"*"},
+		Starred:     Keys{"f"},
+		Due:         Keys{"d"},
+		Compact:     Keys{"c"},
+		Notebook:    Keys{"ctrl+b"},
+		Info:        Keys{"I"},
+		Merge:       Keys{"M"},
+		Read:        Keys{"v"},
+		DateRange:   Keys{"T"},
+		Export:      Keys{"x"},
+		Focus:       Keys{"shift+tab"},
+		Template:    Keys{"N"},
+		Daily:       Keys{"J"},
+		Help:        Keys{"?"},
+		Save:        Keys{"esc"},
+		LineNumbers: Keys{"ctrl+n"},
+		Clear:       Keys{"ctrl+u"},
+		Date:        Keys{"ctrl+d"},
+	}
+}
+
+// validate replaces actions left without keys by their defaults, and falls
+// back to the default key map if a key is bound twice, rebinds ctrl+c or
+// takes a key a view handles itself, like esc or / in the list. Both are
+// logged as warnings.
+func (k KeyMap) validate() KeyMap {
+	type binding struct {
+		name     string
+		keys     *Keys
+		fallback Keys
+	}
+
+	defaults := DefaultKeyMap()
+	listKeys := []binding{
+		{"quit", &k.Quit, defaults.Quit},
+		{"new", &k.New, defaults.New},
+		{"edit", &k.Edit, defaults.Edit},
+		{"delete", &k.Delete, defaults.Delete},
+		{"mark", &k.Mark, defaults.Mark},
+		{"top", &k.Top, defaults.Top},
+		{"bottom", &k.Bottom, defaults.Bottom},
+		{"undo", &k.Undo, defaults.Undo},
+		{"copy", &k.Copy, defaults.Copy},
+		{"external_editor", &k.Editor, defaults.Editor},
+		{"duplicate", &k.Duplicate, defaults.Duplicate},
+		{"write", &k.Write, defaults.Write},
+		{"archive", &k.Archive, defaults.Archive},
+		{"show_archive", &k.Archived, defaults.Archived},
+		{"show_trash", &k.Trash, defaults.Trash},
+		{"show_tags", &k.Tags, defaults.Tags},
+		{"sort", &k.Sort, defaults.Sort},
+		{"toggle_timestamp", &k.Timestamp, defaults.Timestamp},
+		{"toggle_preview", &k.Preview, defaults.Preview},
+		{"reload", &k.Reload, defaults.Reload},
+		{"replace", &k.Replace, defaults.Replace},
+		{"star", &k.Star, defaults.Star},
+		{"show_starred", &k.Starred, defaults.Starred},
+		{"show_due", &k.Due, defaults.Due},
+		{"toggle_compact", &k.Compact, defaults.Compact},
+		{"switch_notebook", &k.Notebook, defaults.Notebook},
+		{"show_info", &k.Info, defaults.Info},
+		{"merge", &k.Merge, defaults.Merge},
+		{"read", &k.Read, defaults.Read},
+		{"date_range", &k.DateRange, defaults.DateRange},
+		{"export_filtered", &k.Export, defaults.Export},
+		{"focus_preview", &k.Focus, defaults.Focus},
+		{"new_from_template", &k.Template, defaults.Template},
+		{"daily", &k.Daily, defaults.Daily},
+		{"help", &k.Help, defaults.Help},
+	}
+	editKeys := []binding{
+		{"save", &k.Save, defaults.Save},
+		{"toggle_line_numbers", &k.LineNumbers, defaults.LineNumbers},
+		{"clear", &k.Clear, defaults.Clear},
+		{"insert_date", &k.Date, defaults.Date},
+	}
+
+	// Each view is checked separately, since their keys never apply at the
+	// same time. fixed holds the keys a view handles itself.
+	for _, group := range []struct {
+		actions []binding
+		fixed   map[string]string
+	}{
+		{listKeys, map[string]string{"esc": "clear_filter", "/": "filter"}},
+		{editKeys, nil},
+		{
+			[]binding{listKeys[0], {"show_trash", &k.Trash, defaults.Trash}},
+			map[string]string{"esc": "close", "enter": "restore", "r": "restore", "e": "edit_deleted", "x": "purge", "X": "empty_trash"},
+		},
+		{
+			[]binding{listKeys[0], {"show_archive", &k.Archived, defaults.Archived}, {"archive", &k.Archive, defaults.Archive}},
+			map[string]string{"esc": "close", "enter": "unarchive"},
+		},
+		{[]binding{listKeys[0], {"show_due", &k.Due, defaults.Due}}, map[string]string{"esc": "close", "enter": "open"}},
+		{[]binding{listKeys[0], {"show_tags", &k.Tags, defaults.Tags}}, map[string]string{"esc": "close", "enter": "open"}},
+		{[]binding{listKeys[0], {"new_from_template", &k.Template, defaults.Template}}, map[string]string{"esc": "close", "enter": "open"}},
+	} {
+		bound := map[string]string{"ctrl+c": "quit"}
+		maps.Copy(bound, group.fixed)
+		for _, a := range group.actions {
+			if len(*a.keys) == 0 {
+				log.Printf("Warning: no keys bound to %q, using %v", a.name, a.fallback)
+				*a.keys = a.fallback
+			}
+			for _, key := range *a.keys {
+				if other, ok := bound[key]; ok {
+					log.Printf("Warning: key %q is bound to both %q and %q, using default keys", key, other, a.name)
+					return defaults
+				}
+				bound[key] = a.name
+			}
+		}
+	}
+	return k
+}
+
+// Theme -----------------------------------------------------------------------
+
+// Theme maps color names to hex values like "#FCB53B" or ANSI codes like "240".
+type Theme struct {
+	Primary    string `json:"primary"`
+	Text       string `json:"text"`
+	Muted      string `json:"muted"`
+	Background string `json:"background"`
+	LineNumber string `json:"line_number"`
+	EndBuffer  string `json:"end_buffer"`
+	Warning    string `json:"warning"`
+	Stale      string `json:"stale"`
+}
+
+func DefaultTheme() Theme {
+	return Theme{
+		Primary:    "#FCB53B",
+		Text:       "250",
+		Muted:      "241",
+		Background: "#1c1b1c",
+		LineNumber: "240",
+		EndBuffer:  "237",
+		Warning:    "#E5534B",
+		Stale:      "#A8674B",
+	}
+}
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// LoadTheme reads the theme file at path. Missing or invalid colors fall back
+// to their default with a logged warning, and a missing file yields the
+// default theme.
+func LoadTheme(path string) (Theme, error) {
+	theme := DefaultTheme()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return theme, nil
+		}
+		return theme, err
+	}
+
+	if err := json.Unmarshal(data, &theme); err != nil {
+		return DefaultTheme(), err
+	}
+
+	defaults := DefaultTheme()
+	for _, c := range []struct {
+		name     string
+		value    *string
+		fallback string
+	}{
+		{"primary", &theme.Primary, defaults.Primary},
+		{"text", &theme.Text, defaults.Text},
+		{"muted", &theme.Muted, defaults.Muted},
+		{"background", &theme.Background, defaults.Background},
+		{"line_number", &theme.LineNumber, defaults.LineNumber},
+		{"end_buffer", &theme.EndBuffer, defaults.EndBuffer},
+		{"warning", &theme.Warning, defaults.Warning},
+		{"stale", &theme.Stale, defaults.Stale},
+	} {
+		if !isValidColor(*c.value) {
+			log.Printf("Warning: invalid theme color %s %q, using %s", c.name, *c.value, c.fallback)
+			*c.value = c.fallback
+		}
+	}
+	return theme, nil
+}
+
+func isValidColor(s string) bool {
+	if hexColorPattern.MatchString(s) {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0 && n <= 255
+}
+
+// State -----------------------------------------------------------------------
+
+// State is what yellow remembers between sessions, kept apart from the memo
+// file so that losing it never costs any memos.
+type State struct {
+	LastSelected string `json:"last_selected"`
+	Notebook     string `json:"notebook,omitempty"`
+
+	// Sort and Preview restore the list layout. Settings toggled in the UI
+	// that also have a config option are saved to the config file instead.
+	Sort    string `json:"sort,omitempty"`
+	Preview bool   `json:"preview,omitempty"`
+
+	path string
+}
+
+// LoadState reads the state file at path. A missing file yields an empty
+// state.
+func LoadState(path string) (State, error) {
+	state := State{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{path: path}, err
+	}
+	return state, nil
+}
+
+func (s State) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// Path Helpers ----------------------------------------------------------------
+
+// localDataFile is the memo file used by --local, and when there is no home
+// directory to put it in.
+const localDataFile = ".yellow.json"
+
+// resolveDataPath returns path if set, otherwise the default memo file.
+func resolveDataPath(path string) string {
+	if path != "" {
+		return path
+	}
+
+	dataPath, err := getDataFilePath("yellow.json")
+	if err != nil {
+		log.Printf("Error getting data path: %v, falling back to current directory", err)
+		return localDataFile
+	}
+	return dataPath
+}
+
+// getDataFilePath returns the path of a file in the data directory, which
+// holds the memos, state and log.
+func getDataFilePath(filename string) (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+
+	// Create the data directory if it doesn't exist
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	return filepath.Join(dataDir, filename), nil
+}
+
+// getConfigFilePath returns the path of a file in the config directory, which
+// holds config.json and theme.json.
+func getConfigFilePath(filename string) (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	// Create the config directory if it doesn't exist
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	return filepath.Join(configDir, filename), nil
+}
+
+// getDataDir returns $YELLOW_HOME when set, otherwise $XDG_DATA_HOME/yellow
+// or ~/.local/share/yellow. Memo files from before the XDG layout stay where
+// they are, in the config directory.
+func getDataDir() (string, error) {
+	if dir := os.Getenv("YELLOW_HOME"); dir != "" {
+		return expandHome(dir)
+	}
+
+	dir, err := xdgDir("XDG_DATA_HOME", ".local", "share")
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "yellow.json")); os.IsNotExist(err) {
+		legacy, err := getConfigDir()
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(legacy, "yellow.json")); err == nil {
+			return legacy, nil
+		}
+	}
+	return dir, nil
+}
+
+// getConfigDir returns $YELLOW_HOME when set, otherwise $XDG_CONFIG_HOME/yellow
+// or ~/.config/yellow.
+func getConfigDir() (string, error) {
+	if dir := os.Getenv("YELLOW_HOME"); dir != "" {
+		return expandHome(dir)
+	}
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// xdgDir returns the yellow directory under the XDG base directory named by
+// env, or under fallback in the home directory. Relative paths in env are
+// ignored, as the XDG spec asks.
+func xdgDir(env string, fallback ...string) (string, error) {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "yellow"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(append(append([]string{homeDir}, fallback...), "yellow")...), nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
+
+// Model -----------------------------------------------------------------------
+
+type ViewMode uint8
+
+const (
+	ViewModeList ViewMode = iota
+	ViewModeEdit
+	ViewModeTrash
+	ViewModeTags
+	ViewModeArchive
+	ViewModeReplace
+	ViewModeDue
+	ViewModeInfo
+	ViewModeRead
+	ViewModeTemplates
+	ViewModeHelp
+)
+
+type Model struct {
+	list     list.Model
+	trash    list.Model
+	archive  list.Model
+	due      list.Model
+	tags     list.Model
+	picker   list.Model
+	textarea textarea.Model
+	reader   viewport.Model
+	preview  viewport.Model
+	keyHelp  viewport.Model
+	backend  Backend
+	saver    *Saver
+
+	// find and replaceWith are the inputs of the find and replace view.
+	find        textinput.Model
+	replaceWith textinput.Model
+	replaceStep replaceStep
+
+	config Config
+	state  State
+
+	// memos, deleted and archived belong to the open notebook. notebooks
+	// holds every notebook, with a stale entry for the open one.
+	notebook    string
+	notebooks   map[string]*Notebook
+	memos       []Memo
+	deleted     []Memo
+	archived    []Memo
+	currentMode ViewMode
+	currentMemo *Memo
+	// original is the editor's text as the memo was opened or last stored.
+	// The textarea turns tabs into spaces, so it can differ from the memo.
+	original string
+
+	// undoStack holds the IDs of deleted memos, most recent last.
+	undoStack []string
+
+	// marked holds the IDs of memos picked for a bulk action. The list
+	// delegate shares this map, so it is cleared in place, never replaced.
+	marked map[string]struct{}
+
+	// cursors remembers where the cursor was when each memo was last left
+	// in the editor, so editing it again picks up from there.
+	cursors map[string]cursorPos
+
+	// tagFilter narrows the list to memos carrying this tag when non-empty,
+	// starredOnly to starred memos and dateRange to recently updated ones.
+	tagFilter   string
+	starredOnly bool
+	dateRange   DateRange
+	sortMode    SortMode
+
+	// vim tracks the editor's sub-mode when the vim_mode setting is on.
+	vim vimState
+
+	// cleared holds the editor's text after it was cleared, so that it can
+	// be brought back.
+	cleared string
+
+	// autosaveTag identifies the current editing session so that ticks
+	// scheduled for an earlier session are ignored.
+	autosaveTag int
+
+	// fileInfo describes the memo file while the info view is open.
+	fileInfo os.FileInfo
+
+	// reading is the memo shown in the reader, kept to render it again when
+	// the window is resized.
+	reading Memo
+
+	// helpFrom is the view the key bindings were opened from.
+	helpFrom ViewMode
+
+	// duplicate is the memo that a new memo being saved repeats.
+	duplicate Memo
+
+	// previewFocused routes keys to the preview pane to scroll it, and
+	// previewFor is the memo its scroll offset belongs to.
+	previewFocused bool
+	previewFor     string
+
+	// status is a transient message shown in place of the help line.
+	status    string
+	statusTag int
+
+	// saved shows "Saved ✓" for a moment after a save, and saveErr shows
+	// "Unsaved changes" until a save succeeds. saveTag works like statusTag.
+	saved   bool
+	saveErr error
+	saveTag int
+
+	flags uint16
+
+	savedFilterValue string
+	width, height    int
+}
+
+type SortMode uint8
+
+const (
+	SortByUpdated SortMode = iota
+	SortByCreated
+	SortByTitle
+)
+
+func (s SortMode) String() string {
+	switch s {
+	case SortByCreated:
+		return "created"
+	case SortByTitle:
+		return "title"
+	default:
+		return "updated"
+	}
+}
+
+func (s SortMode) Next() SortMode { return (s + 1) % 3 }
+
+// parseSortMode is the inverse of String. Unknown names sort by update time.
+func parseSortMode(name string) SortMode {
+	for mode := SortByUpdated; mode <= SortByTitle; mode++ {
+		if mode.String() == name {
+			return mode
+		}
+	}
+	return SortByUpdated
+}
+
+// DateRange narrows the list to memos updated since the start of the day,
+// week or month.
+type DateRange uint8
+
+const (
+	RangeAll DateRange = iota
+	RangeToday
+	RangeWeek
+	RangeMonth
+)
+
+func (r DateRange) String() string {
+	switch r {
+	case RangeToday:
+		return "today"
+	case RangeWeek:
+		return "this week"
+	case RangeMonth:
+		return "this month"
+	default:
+		return "any time"
+	}
+}
+
+func (r DateRange) Next() DateRange { return (r + 1) % 4 }
+
+// Start is the local midnight the range begins at on the day of now, or the
+// zero time for RangeAll. Weeks start on Monday.
+func (r DateRange) Start(now time.Time) time.Time {
+	y, mo, d := now.Date()
+	switch r {
+	case RangeToday:
+		return time.Date(y, mo, d, 0, 0, 0, 0, now.Location())
+	case RangeWeek:
+		sinceMonday := (int(now.Weekday()) + 6) % 7
+		return time.Date(y, mo, d-sinceMonday, 0, 0, 0, 0, now.Location())
+	case RangeMonth:
+		return time.Date(y, mo, 1, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+const (
+	flagIsNewMemo        uint16 = 1 << 0
+	flagWasFiltered      uint16 = 1 << 1
+	flagConfirmingDelete uint16 = 1 << 2
+	flagShowPreview      uint16 = 1 << 3
+	flagQuickCapture     uint16 = 1 << 4
+	flagConfirmingPurge  uint16 = 1 << 5
+	flagConfirmingEmpty  uint16 = 1 << 6
+	flagRestoring        uint16 = 1 << 7
+	flagOpenDaily        uint16 = 1 << 8
+	// flagCheckDuplicate asks before saving a new memo that repeats an
+	// existing one, and flagConfirmingDuplicate is set while asking.
+	flagCheckDuplicate      uint16 = 1 << 9
+	flagConfirmingDuplicate uint16 = 1 << 10
+)
+
+// replaceStep is how far along the find and replace view is.
+type replaceStep int
+
+const (
+	replaceFind replaceStep = iota
+	replaceWith
+	replaceConfirm
+)
+
+// vimMode is the editor's sub-mode when the vim_mode setting is on.
+type vimMode int
+
+const (
+	vimInsert vimMode = iota
+	vimNormal
+	vimCommand
+)
+
+type vimState struct {
+	mode    vimMode
+	pending string // first key of a two-key command like "dd"
+	command string // what has been typed after ":"
+}
+
+func (m *Model) setFlag(flag uint16)      { m.flags |= flag }
+func (m *Model) clearFlag(flag uint16)    { m.flags &^= flag }
+func (m *Model) hasFlag(flag uint16) bool { return m.flags&flag != 0 }
+
+func InitialModel(backend Backend, cfg Config, state State) Model {
+	m := Model{
+		list:        newList("Yellow", make([]list.Item, 0, 32), cfg),
+		trash:       newTrashList(make([]list.Item, 0, 8), cfg),
+		archive:     newArchiveList(make([]list.Item, 0, 8), cfg),
+		due:         newDueList(),
+		tags:        newTagList(),
+		picker:      newTemplateList(),
+		textarea:    newTextarea(),
+		find:        newTextinput("Find: "),
+		replaceWith: newTextinput("Replace with: "),
+		backend:     backend,
+		saver:       NewSaver(backend),
+		config:      cfg,
+		state:       state,
+		notebook:    cmp.Or(state.Notebook, defaultNotebook),
+		sortMode:    parseSortMode(state.Sort),
+		memos:       make([]Memo, 0, 32),
+		deleted:     make([]Memo, 0, 8),
+		archived:    make([]Memo, 0, 8),
+		marked:      make(map[string]struct{}),
+		cursors:     make(map[string]cursorPos),
+		currentMode: ViewModeList,
+	}
+	m.list.SetDelegate(m.delegate())
+	m.textarea.ShowLineNumbers = cfg.LineNumbers
+	if state.Preview {
+		m.setFlag(flagShowPreview)
+	}
+	return m
+}
+
+// QuickCapture opens the model straight on a new memo and makes it quit once
+// that memo is saved.
+func (m Model) QuickCapture() Model {
+	model, _ := m.createNew("")
+	m = model.(Model)
+	m.setFlag(flagQuickCapture)
+	return m
+}
+
+// Daily opens the model on today's daily note once the memos are loaded, and
+// makes it quit once that note is saved.
+func (m Model) Daily() Model {
+	m.setFlag(flagOpenDaily | flagQuickCapture)
+	return m
+}
+
+func (m Model) Init() tea.Cmd {
+	if m.hasFlag(flagQuickCapture) && !m.hasFlag(flagOpenDaily) {
+		return tea.Batch(loadMemos(m.saver), textarea.Blink, m.autosaveTick())
+	}
+	return loadMemos(m.saver)
+}
+
+// Update ----------------------------------------------------------------------
+
+type loadMemosMsg struct {
+	data   *MemoData
+	err    error
+	reload bool
+}
+
+type saveCompleteMsg struct{ err error }
+
+type autosaveMsg struct{ tag int }
+
+type clearStatusMsg struct{ tag int }
+
+type clearSavedMsg struct{ tag int }
+
+type editorFinishedMsg struct {
+	id   string
+	path string
+	err  error
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case loadMemosMsg:
+		if msg.err != nil {
+			log.Printf("Error loading: %v", msg.err)
+			var corrupt *CorruptFileError
+			if errors.As(msg.err, &corrupt) {
+				m.corruptFileStatus(corrupt, msg.reload)
+				return m, nil
+			}
+			if msg.reload {
+				return m, m.setStatus("Reload failed, kept current memos")
+			}
+			return m, nil
+		}
+		if msg.reload {
+			// Saves queued since the reload was read hold the memos it
+			// replaces.
+			m.saver.DropPending()
+		}
+		nb := msg.data.Notebook(m.notebook)
+		m.notebooks = msg.data.Notebooks
+		m.memos, m.deleted, m.archived = nb.Active, nb.Deleted, nb.Archived
+		warnOverLimit(m.memos, m.config.CharLimit)
+		m.refreshLists()
+		if msg.reload {
+			return m, m.setStatus("Reloaded from disk")
+		}
+		if !m.selectMemo(m.state.LastSelected) {
+			m.list.Select(0)
+		}
+		if m.hasFlag(flagOpenDaily) {
+			m.clearFlag(flagOpenDaily)
+			return m.openDaily()
+		}
+		return m, nil
+
+	case autosaveMsg:
+		if msg.tag != m.autosaveTag || m.currentMode != ViewModeEdit {
+			return m, nil
+		}
+		return m.autosave()
+
+	case fileChangedMsg:
+		if s, ok := m.backend.(*Storage); ok && s.ChangedOnDisk() {
+			return m, reloadMemos(m.saver)
+		}
+		return m, nil
+
+	case editorFinishedMsg:
+		return m.applyEditorResult(msg)
+
+	case clearStatusMsg:
+		if msg.tag == m.statusTag {
+			m.status = ""
+		}
+		return m, nil
+
+	case clearSavedMsg:
+		if msg.tag == m.saveTag {
+			m.saved = false
+		}
+		return m, nil
+
+	case saveCompleteMsg:
+		m.saveErr = msg.err
+		m.saveTag++
+		if msg.err != nil {
+			m.saved = false
+			log.Printf("Error saving: %v", msg.err)
+			cmd := m.setStatus("Error saving memos: " + msg.err.Error())
+			if m.config.NotifyErrors {
+				cmd = tea.Batch(cmd, notifyCmd("yellow: memos not saved", msg.err.Error()))
+			}
+			return m, cmd
+		}
+		m.saved = true
+		tag := m.saveTag
+		return m, tea.Tick(2*time.Second, func(time.Time) tea.Msg {
+			return clearSavedMsg{tag}
+		})
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.resizeComponents()
+		return m, nil
+
+	case tea.KeyMsg:
+		if m.currentMode == ViewModeHelp {
+			return m.handleHelpKeys(msg)
+		}
+		if m.config.Keys.Help.Matches(msg) && m.canShowHelp() {
+			return m.openHelp()
+		}
+		switch m.currentMode {
+		case ViewModeList:
+			return m.handleListKeys(msg)
+		case ViewModeTrash:
+			return m.handleTrashKeys(msg)
+		case ViewModeTags:
+			return m.handleTagKeys(msg)
+		case ViewModeTemplates:
+			return m.handleTemplateKeys(msg)
+		case ViewModeArchive:
+			return m.handleArchiveKeys(msg)
+		case ViewModeReplace:
+			return m.handleReplaceKeys(msg)
+		case ViewModeDue:
+			return m.handleDueKeys(msg)
+		case ViewModeInfo:
+			return m.handleInfoKeys(msg)
+		case ViewModeRead:
+			return m.handleReadKeys(msg)
+		}
+		return m.handleEditKeys(msg)
+	}
+
+	return m.updateActiveComponent(msg)
+}
+
+func (m Model) handleListKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.previewFocused && m.previewVisible() {
+		return m.handlePreviewKeys(msg)
+	}
+	m.previewFocused = false
+
+	if m.hasFlag(flagConfirmingDelete) {
+		m.clearFlag(flagConfirmingDelete)
+		if msg.String() == "y" {
+			if len(m.marked) > 0 {
+				return m.deleteMarked()
+			}
+			return m.deleteSelected()
+		}
+		return m, nil
+	}
+
+	filterState := m.list.FilterState()
+
+	if filterState == list.Filtering {
+		if msg.Type == tea.KeyRunes {
+			// Pasted text can hold newlines, tabs and other control
+			// characters that don't belong in the one-line filter.
+			msg.Runes = singleLine(msg.Runes)
+			if len(msg.Runes) == 0 {
+				return m, nil
+			}
+		}
+		var cmd tea.Cmd
+		m.list, cmd = m.list.Update(msg)
+		if msg.String() == "esc" {
+			m.list.ResetFilter()
+		}
+		return m, cmd
+	}
+
+	if filterState == list.FilterApplied {
+		if msg.String() == "esc" {
+			m.list.ResetFilter()
+			return m, nil
+		}
+		if m.config.Keys.Edit.Matches(msg) && len(m.memos) > 0 {
+			return m.editSelected()
+		}
+		if m.config.Keys.Export.Matches(msg) {
+			return m.exportFiltered()
+		}
+		var cmd tea.Cmd
+		m.list, cmd = m.list.Update(msg)
+		return m, cmd
+	}
+
+	keys := m.config.Keys
+	switch {
+	case msg.String() == "ctrl+c", keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case keys.New.Matches(msg):
+		return m.createNew("")
+	case keys.Template.Matches(msg):
+		return m.openTemplates()
+	case keys.Daily.Matches(msg):
+		return m.openDaily()
+	case keys.Trash.Matches(msg):
+		return m.openTrash()
+	case keys.Archive.Matches(msg):
+		return m.archiveSelected()
+	case keys.Archived.Matches(msg):
+		return m.openArchive()
+	case keys.Undo.Matches(msg):
+		if len(m.undoStack) > 0 {
+			return m.undoDelete()
+		}
+	case keys.Copy.Matches(msg):
+		return m.copySelected()
+	case keys.Editor.Matches(msg):
+		return m.openInEditor()
+	case keys.Duplicate.Matches(msg):
+		if item := m.list.SelectedItem(); item != nil {
+			// A copy made on purpose is not warned about.
+			model, cmd := m.createNew(item.(Memo).Content)
+			m = model.(Model)
+			m.clearFlag(flagCheckDuplicate)
+			return m, cmd
+		}
+	case keys.Write.Matches(msg):
+		return m.writeSelected()
+	case keys.Sort.Matches(msg):
+		m.sortMode = m.sortMode.Next()
+		m.refreshLists()
+		return m, nil
+	case keys.Timestamp.Matches(msg):
+		return m.toggleTimestamp()
+	case keys.Compact.Matches(msg):
+		return m.toggleCompact()
+	case keys.Notebook.Matches(msg):
+		return m.nextNotebook()
+	case keys.Info.Matches(msg):
+		return m.openInfo()
+	case keys.Read.Matches(msg):
+		return m.openReader()
+	case keys.Preview.Matches(msg):
+		m.flags ^= flagShowPreview
+		m.resizeComponents()
+		return m, nil
+	case keys.Focus.Matches(msg):
+		if m.previewVisible() && m.list.SelectedItem() != nil {
+			m.preview = m.previewPane()
+			m.previewFocused = true
+			m.previewFor = m.list.SelectedItem().(Memo).ID
+		}
+		return m, nil
+	case keys.Reload.Matches(msg):
+		return m, reloadMemos(m.saver)
+	case keys.Replace.Matches(msg):
+		return m.openReplace()
+	case keys.Star.Matches(msg):
+		return m.toggleStar()
+	case keys.Due.Matches(msg):
+		return m.openDue()
+	case keys.Starred.Matches(msg):
+		m.starredOnly = !m.starredOnly
+		m.refreshLists()
+		m.list.ResetSelected()
+		return m, nil
+	case keys.DateRange.Matches(msg):
+		m.dateRange = m.dateRange.Next()
+		m.refreshLists()
+		m.list.ResetSelected()
+		return m, nil
+	case keys.Tags.Matches(msg):
+		return m.openTags()
+	case keys.Top.Matches(msg):
+		m.list.Select(0)
+		return m, nil
+	case keys.Bottom.Matches(msg):
+		m.list.Select(max(len(m.list.VisibleItems())-1, 0))
+		return m, nil
+	case keys.Mark.Matches(msg):
+		if item := m.list.SelectedItem(); item != nil {
+			m.toggleMarked(item.(Memo).ID)
+			m.list.CursorDown()
+		}
+		return m, nil
+	case msg.String() == "esc":
+		if len(m.marked) > 0 {
+			clear(m.marked)
+			return m, nil
+		}
+		if m.tagFilter != "" || m.starredOnly || m.dateRange != RangeAll {
+			m.tagFilter = ""
+			m.starredOnly = false
+			m.dateRange = RangeAll
+			m.refreshLists()
+			return m, nil
+		}
+	case keys.Delete.Matches(msg):
+		if m.list.SelectedItem() != nil || len(m.marked) > 0 {
+			if m.config.ConfirmDelete {
+				m.setFlag(flagConfirmingDelete)
+				return m, nil
+			}
+			if len(m.marked) > 0 {
+				return m.deleteMarked()
+			}
+			return m.deleteSelected()
+		}
+	case keys.Edit.Matches(msg):
+		if len(m.memos) > 0 {
+			return m.editSelected()
+		}
+	case keys.Merge.Matches(msg):
+		if len(m.marked) > 0 {
+			return m.mergeMarked()
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleEditKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.hasFlag(flagConfirmingDuplicate) {
+		m.clearFlag(flagConfirmingDuplicate)
+		switch msg.String() {
+		case "k":
+			m.clearFlag(flagCheckDuplicate)
+			return m.saveAndExit()
+		case "m":
+			return m.mergeDuplicate()
+		case "d":
+			return m.discardDuplicate()
+		}
+		m.resizeComponents()
+		return m, nil
+	}
+	if m.config.Keys.LineNumbers.Matches(msg) {
+		return m.toggleLineNumbers()
+	}
+	if m.config.Keys.Clear.Matches(msg) {
+		return m.clearTextarea()
+	}
+	if m.config.Keys.Date.Matches(msg) {
+		m.textarea.InsertString(time.Now().Format(m.config.DateFormat))
+		return m, nil
+	}
+	if m.config.VimMode {
+		return m.handleVimKeys(msg)
+	}
+
+	switch {
+	case m.config.Keys.Save.Matches(msg):
+		return m.saveAndExit()
+	case msg.String() == "ctrl+c":
+		return m.quitEditing()
+	}
+
+	var cmd tea.Cmd
+	m.textarea, cmd = m.textarea.Update(msg)
+	return m, cmd
+}
+
+// handleVimKeys layers vim's modes over the editor: the save key leaves insert
+// mode, and only a second press (or :q) saves and returns to the list.
+func (m Model) handleVimKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.String() == "ctrl+c" {
+		return m.quitEditing()
+	}
+
+	switch m.vim.mode {
+	case vimInsert:
+		if m.config.Keys.Save.Matches(msg) {
+			m.vim.mode = vimNormal
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.textarea, cmd = m.textarea.Update(msg)
+		return m, cmd
+
+	case vimCommand:
+		switch msg.String() {
+		case "esc":
+			m.vim = vimState{mode: vimNormal}
+		case "enter":
+			command := m.vim.command
+			m.vim = vimState{mode: vimNormal}
+			return m.runVimCommand(command)
+		case "backspace":
+			if m.vim.command == "" {
+				m.vim.mode = vimNormal
+			} else {
+				_, size := utf8.DecodeLastRuneInString(m.vim.command)
+				m.vim.command = m.vim.command[:len(m.vim.command)-size]
+			}
+		default:
+			if msg.Type == tea.KeyRunes {
+				m.vim.command += string(msg.Runes)
+			}
+		}
+		return m, nil
+	}
+
+	pending := m.vim.pending
+	m.vim.pending = ""
+
+	switch msg.String() {
+	case "esc":
+		return m.saveAndExit()
+	case "h", "left":
+		return m.sendToTextarea(tea.KeyMsg{Type: tea.KeyLeft})
+	case "l", "right":
+		return m.sendToTextarea(tea.KeyMsg{Type: tea.KeyRight})
+	case "j", "down":
+		m.textarea.CursorDown()
+	case "k", "up":
+		m.textarea.CursorUp()
+	case "0", "home":
+		m.textarea.CursorStart()
+	case "$", "end":
+		m.textarea.CursorEnd()
+	case "x":
+		return m.sendToTextarea(tea.KeyMsg{Type: tea.KeyDelete})
+	case "i":
+		m.vim.mode = vimInsert
+	case "a":
+		m.vim.mode = vimInsert
+		return m.sendToTextarea(tea.KeyMsg{Type: tea.KeyRight})
+	case "A":
+		m.textarea.CursorEnd()
+		m.vim.mode = vimInsert
+	case "o":
+		m.textarea.CursorEnd()
+		m.textarea.InsertString("\n")
+		m.vim.mode = vimInsert
+	case "O":
+		m.textarea.CursorStart()
+		m.textarea.InsertString("\n")
+		m.textarea.CursorUp()
+		m.vim.mode = vimInsert
+	case "d":
+		if pending == "d" {
+			m.deleteLine()
+		} else {
+			m.vim.pending = "d"
+		}
+	case ":":
+		m.vim.mode = vimCommand
+	}
+	return m, nil
+}
+
+func (m Model) sendToTextarea(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	m.textarea, cmd = m.textarea.Update(msg)
+	return m, cmd
+}
+
+// runVimCommand runs an ex command typed after ":".
+func (m Model) runVimCommand(command string) (tea.Model, tea.Cmd) {
+	switch strings.TrimSpace(command) {
+	case "w":
+		cmd := m.storeCurrent()
+		return m, cmd
+	case "q", "wq", "x":
+		return m.saveAndExit()
+	case "":
+		return m, nil
+	}
+	return m, m.setStatus("Not an editor command: " + command)
+}
+
+// deleteLine removes the line under the cursor and leaves the cursor at the
+// start of the line that took its place.
+func (m *Model) deleteLine() {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	row := m.textarea.Line()
+	lines = append(lines[:row], lines[row+1:]...)
+
+	m.textarea.SetValue(strings.Join(lines, "\n"))
+	row = min(row, max(len(lines)-1, 0))
+	for m.textarea.Line() > row {
+		m.textarea.CursorUp()
+	}
+	m.textarea.CursorStart()
+}
+
+func (m Model) handleTrashKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.hasFlag(flagConfirmingPurge | flagConfirmingEmpty) {
+		emptyAll := m.hasFlag(flagConfirmingEmpty)
+		m.clearFlag(flagConfirmingPurge | flagConfirmingEmpty)
+		if msg.String() != "y" {
+			return m, nil
+		}
+		if emptyAll {
+			return m.emptyTrash()
+		}
+		return m.purgeSelected()
+	}
+
+	switch {
+	case msg.String() == "ctrl+c", m.config.Keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Trash.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case msg.String() == "enter", msg.String() == "r":
+		if len(m.deleted) > 0 {
+			return m.restoreSelected()
+		}
+	case msg.String() == "e":
+		if item := m.trash.SelectedItem(); item != nil {
+			return m.editDeleted(item.(Memo))
+		}
+	case msg.String() == "x":
+		if m.trash.SelectedItem() != nil {
+			m.setFlag(flagConfirmingPurge)
+			return m, nil
+		}
+	case msg.String() == "X":
+		if len(m.deleted) > 0 {
+			m.setFlag(flagConfirmingEmpty)
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.trash, cmd = m.trash.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleArchiveKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c", m.config.Keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Archived.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case msg.String() == "enter", m.config.Keys.Archive.Matches(msg):
+		if len(m.archived) > 0 {
+			return m.unarchiveSelected()
+		}
+	}
+
+	var cmd tea.Cmd
+	m.archive, cmd = m.archive.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleDueKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c", m.config.Keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Due.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case msg.String() == "enter":
+		if item := m.due.SelectedItem(); item != nil {
+			return m.editMemo(item.(dueItem).Memo)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.due, cmd = m.due.Update(msg)
+	return m, cmd
+}
+
+// handleInfoKeys closes the info view on any key but quit.
+func (m Model) handleInfoKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.String() == "ctrl+c" || m.config.Keys.Quit.Matches(msg) {
+		return m, tea.Quit
+	}
+	m.currentMode = ViewModeList
+	m.fileInfo = nil
+	m.resizeComponents()
+	return m, nil
+}
+
+// handlePreviewKeys scrolls the focused preview pane until focus goes back
+// to the list.
+func (m Model) handlePreviewKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	keys := m.config.Keys
+	switch {
+	case msg.String() == "ctrl+c", keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", keys.Focus.Matches(msg):
+		m.previewFocused = false
+		return m, nil
+	case keys.Preview.Matches(msg):
+		m.previewFocused = false
+		m.flags ^= flagShowPreview
+		m.resizeComponents()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.preview, cmd = m.previewPane().Update(msg)
+	return m, cmd
+}
+
+// handleReadKeys scrolls the reader, which can't change the memo. Edit opens
+// the memo in the editor.
+func (m Model) handleReadKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	keys := m.config.Keys
+	switch {
+	case msg.String() == "ctrl+c", keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", keys.Read.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case keys.Edit.Matches(msg):
+		return m.editMemo(m.reading)
+	}
+
+	var cmd tea.Cmd
+	m.reader, cmd = m.reader.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleHelpKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c":
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Help.Matches(msg):
+		m.currentMode = m.helpFrom
+		m.resizeComponents()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.keyHelp, cmd = m.keyHelp.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleReplaceKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.String() == "ctrl+c" {
+		return m, tea.Quit
+	}
+
+	if m.replaceStep == replaceConfirm {
+		if msg.String() == "y" && m.countReplacements() > 0 {
+			return m.replaceAll()
+		}
+		m.closeReplace()
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "esc":
+		m.closeReplace()
+		return m, nil
+	case "enter":
+		if m.replaceStep == replaceFind {
+			if m.find.Value() == "" {
+				return m, nil
+			}
+			m.replaceStep = replaceWith
+			m.find.Blur()
+			return m, m.replaceWith.Focus()
+		}
+		m.replaceStep = replaceConfirm
+		m.replaceWith.Blur()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if m.replaceStep == replaceFind {
+		m.find, cmd = m.find.Update(msg)
+	} else {
+		m.replaceWith, cmd = m.replaceWith.Update(msg)
+	}
+	return m, cmd
+}
+
+func (m Model) handleTemplateKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c", m.config.Keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Template.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case msg.String() == "enter":
+		if item := m.picker.SelectedItem(); item != nil {
+			return m.createNew(expandTemplate(item.(templateItem).content, time.Now(), m.config.DateFormat))
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.picker, cmd = m.picker.Update(msg)
+	return m, cmd
+}
+
+func (m Model) handleTagKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c", m.config.Keys.Quit.Matches(msg):
+		return m, tea.Quit
+	case msg.String() == "esc", m.config.Keys.Tags.Matches(msg):
+		m.currentMode = ViewModeList
+		m.resizeComponents()
+		return m, nil
+	case msg.String() == "enter":
+		if item := m.tags.SelectedItem(); item != nil {
+			m.tagFilter = item.(tagItem).name
+			m.currentMode = ViewModeList
+			m.refreshLists()
+			m.list.ResetSelected()
+			m.resizeComponents()
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.tags, cmd = m.tags.Update(msg)
+	return m, cmd
+}
+
+func (m Model) updateActiveComponent(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch m.currentMode {
+	case ViewModeList:
+		m.list, cmd = m.list.Update(msg)
+	case ViewModeTrash:
+		m.trash, cmd = m.trash.Update(msg)
+	case ViewModeTags:
+		m.tags, cmd = m.tags.Update(msg)
+	case ViewModeTemplates:
+		m.picker, cmd = m.picker.Update(msg)
+	case ViewModeArchive:
+		m.archive, cmd = m.archive.Update(msg)
+	case ViewModeDue:
+		m.due, cmd = m.due.Update(msg)
+	case ViewModeInfo:
+	case ViewModeRead:
+		m.reader, cmd = m.reader.Update(msg)
+	case ViewModeHelp:
+		m.keyHelp, cmd = m.keyHelp.Update(msg)
+	case ViewModeReplace:
+		if m.replaceStep == replaceFind {
+			m.find, cmd = m.find.Update(msg)
+		} else {
+			m.replaceWith, cmd = m.replaceWith.Update(msg)
+		}
+	default:
+		m.textarea, cmd = m.textarea.Update(msg)
+	}
+	return m, cmd
+}
+
+// Update Commands -------------------------------------------------------------
+
+// createNew opens the editor on a new memo seeded with content.
+func (m Model) createNew(content string) (tea.Model, tea.Cmd) {
+	m.saveFilterState()
+	m.currentMemo = &Memo{
+		ID:        generateID(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	m.setFlag(flagIsNewMemo)
+	if m.config.WarnDuplicates {
+		m.setFlag(flagCheckDuplicate)
+	}
+	m.currentMode = ViewModeEdit
+	m.vim = vimState{}
+	m.cleared = ""
+	m.textarea.CharLimit = 0
+	m.textarea.SetValue(content)
+	m.original = m.textarea.Value()
+	if m.config.CharLimit > 0 {
+		m.textarea.CharLimit = max(m.config.CharLimit, m.textarea.Length())
+	}
+	m.textarea.Focus()
+	m.resizeComponents()
+	m.autosaveTag++
+	return m, tea.Batch(textarea.Blink, m.autosaveTick())
+}
+
+func (m Model) editSelected() (tea.Model, tea.Cmd) {
+	if item := m.list.SelectedItem(); item != nil {
+		return m.editMemo(item.(Memo))
+	}
+	return m, nil
+}
+
+func (m Model) editMemo(memo Memo) (tea.Model, tea.Cmd) {
+	m.saveFilterState()
+	m.currentMemo = &memo
+	m.clearFlag(flagIsNewMemo)
+	m.currentMode = ViewModeEdit
+	m.vim = vimState{}
+	m.cleared = ""
+	// Never let the limit truncate a memo that is already longer.
+	m.textarea.CharLimit = 0
+	m.textarea.SetValue(memo.Content)
+	m.original = m.textarea.Value()
+	if m.config.CharLimit > 0 {
+		m.textarea.CharLimit = max(m.config.CharLimit, m.textarea.Length())
+	}
+	if pos, ok := m.cursors[memo.ID]; ok {
+		m.setCursorPos(pos)
+	}
+	m.textarea.Focus()
+	m.resizeComponents()
+	m.autosaveTag++
+	return m, tea.Batch(textarea.Blink, m.autosaveTick())
+}
+
+// editDeleted opens a memo from the trash in the editor. Saving it restores
+// it with the edited content.
+func (m Model) editDeleted(memo Memo) (tea.Model, tea.Cmd) {
+	model, cmd := m.editMemo(memo)
+	m = model.(Model)
+	m.setFlag(flagRestoring)
+	return m, cmd
+}
+
+func (m Model) deleteSelected() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	memo := item.(Memo)
+	for i := range m.memos {
+		if m.memos[i].ID == memo.ID {
+			now := time.Now()
+			memo.DeletedAt = &now
+			m.deleted = append(m.deleted, memo)
+			m.undoStack = append(m.undoStack, memo.ID)
+			delete(m.cursors, memo.ID)
+			// Efficient slice deletion
+			m.memos = append(m.memos[:i], m.memos[i+1:]...)
+			break
+		}
+	}
+
+	m.refreshLists()
+	return m, m.save()
+}
+
+func (m Model) copySelected() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	if err := clipboard.WriteAll(item.(Memo).Content); err != nil {
+		log.Printf("Warning: failed to copy to clipboard: %v", err)
+		return m, m.setStatus("Clipboard unavailable")
+	}
+	return m, m.setStatus("Copied to clipboard")
+}
+
+// openInEditor suspends the TUI and opens the selected memo in $EDITOR via a
+// temporary file, falling back to vi.
+func (m Model) openInEditor() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+	memo := item.(Memo)
+
+	f, err := os.CreateTemp("", "yellow-*.md")
+	if err != nil {
+		log.Printf("Error creating temp file for editor: %v", err)
+		return m, m.setStatus("Could not open editor")
+	}
+	_, err = f.WriteString(memo.Content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("Error writing temp file for editor: %v", err)
+		return m, m.setStatus("Could not open editor")
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	c := exec.Command(editor[0], append(editor[1:], f.Name())...)
+
+	path := f.Name()
+	return m, tea.ExecProcess(c, func(err error) tea.Msg {
+		return editorFinishedMsg{memo.ID, path, err}
+	})
+}
+
+func (m Model) applyEditorResult(msg editorFinishedMsg) (tea.Model, tea.Cmd) {
+	defer os.Remove(msg.path)
+
+	if msg.err != nil {
+		log.Printf("Editor exited with error: %v", msg.err)
+		return m, m.setStatus("Editor failed, memo left unchanged")
+	}
+
+	data, err := os.ReadFile(msg.path)
+	if err != nil {
+		log.Printf("Error reading editor result: %v", err)
+		return m, m.setStatus("Editor failed, memo left unchanged")
+	}
+
+	for i := range m.memos {
+		if m.memos[i].ID != msg.id {
+			continue
+		}
+		content := string(data)
+		// Most editors add a final newline the memo didn't have.
+		if !strings.HasSuffix(m.memos[i].Content, "\n") {
+			content = strings.TrimSuffix(content, "\n")
+		}
+		if content == m.memos[i].Content {
+			return m, nil
+		}
+		m.memos[i].Content = content
+		m.memos[i].UpdatedAt = time.Now()
+		m.archiveOverLimit()
+		m.refreshLists()
+		return m, m.save()
+	}
+	return m, nil
+}
+
+// exportFiltered writes the memos the filter shows, in the order shown, to a
+// JSON file in the working directory named after the filter term.
+func (m Model) exportFiltered() (tea.Model, tea.Cmd) {
+	items := m.list.VisibleItems()
+	if len(items) == 0 {
+		return m, m.setStatus("No memos to export")
+	}
+	memos := make([]Memo, len(items))
+	for i, item := range items {
+		memos[i] = item.(Memo)
+	}
+
+	path, err := writeFilteredFile(".", m.list.FilterValue(), memos)
+	if err != nil {
+		log.Printf("Error exporting filtered memos: %v", err)
+		return m, m.setStatus("Could not export memos")
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return m, m.setStatus(fmt.Sprintf("Exported %s to %s", plural(len(memos), "memo"), path))
+}
+
+// writeSelected saves the selected memo to a file in the working directory.
+func (m Model) writeSelected() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	path, err := writeMemoFile(".", item.(Memo))
+	if err != nil {
+		log.Printf("Error writing memo to file: %v", err)
+		return m, m.setStatus("Could not write memo to file")
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return m, m.setStatus("Written to " + path)
+}
+
+func (m Model) toggleLineNumbers() (tea.Model, tea.Cmd) {
+	m.config.LineNumbers = !m.config.LineNumbers
+	m.textarea.ShowLineNumbers = m.config.LineNumbers
+	// The gutter width changed, so the text width has to be recomputed.
+	m.resizeComponents()
+
+	if err := m.config.SaveSetting("line_numbers", m.config.LineNumbers); err != nil {
+		log.Printf("Error saving config: %v", err)
+	}
+	return m, nil
+}
+
+// clearTextarea empties the editor. Pressing the key again while it is still
+// empty brings the text back.
+func (m Model) clearTextarea() (tea.Model, tea.Cmd) {
+	key := m.config.Keys.Clear.String()
+	if m.textarea.Value() == "" {
+		if m.cleared == "" {
+			return m, nil
+		}
+		m.textarea.SetValue(m.cleared)
+		m.cleared = ""
+		return m, m.setStatus("Text restored")
+	}
+
+	m.cleared = m.textarea.Value()
+	m.textarea.Reset()
+	return m, m.setStatus("Cleared • " + key + " again to undo")
+}
+
+func (m Model) toggleStar() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	id := item.(Memo).ID
+	for i := range m.memos {
+		if m.memos[i].ID == id {
+			m.memos[i].Starred = !m.memos[i].Starred
+			break
+		}
+	}
+
+	m.refreshLists()
+	return m, m.save()
+}
+
+// toggleTimestamp switches list descriptions between the updated and created
+// time and remembers the choice in the config file.
+func (m Model) toggleTimestamp() (tea.Model, tea.Cmd) {
+	m.config.ShowCreated = !m.config.ShowCreated
+	m.setDelegates()
+
+	if err := m.config.SaveSetting("show_created", m.config.ShowCreated); err != nil {
+		log.Printf("Error saving config: %v", err)
+	}
+	return m, nil
+}
+
+// nextNotebook opens the next notebook in alphabetical order.
+func (m Model) nextNotebook() (tea.Model, tea.Cmd) {
+	names := notebookNames(m.notebooks)
+	if len(names) < 2 {
+		return m, m.setStatus("No other notebooks • start yellow with --notebook <name> to add one")
+	}
+
+	name := names[(slices.Index(names, m.notebook)+1)%len(names)]
+	m.openNotebook(name)
+	return m, m.setStatus("Notebook: " + name)
+}
+
+// openNotebook swaps the open notebook's memos for those of another, and
+// drops the filters, marks and undo history that belonged to the old one.
+func (m *Model) openNotebook(name string) {
+	m.notebooks[m.notebook] = &Notebook{Active: m.memos, Deleted: m.deleted, Archived: m.archived}
+	nb := m.notebooks[name]
+	m.notebook = name
+	m.memos, m.deleted, m.archived = nb.Active, nb.Deleted, nb.Archived
+
+	m.undoStack = nil
+	clear(m.marked)
+	m.tagFilter, m.starredOnly, m.dateRange = "", false, RangeAll
+	m.list.ResetFilter()
+	m.refreshLists()
+	m.list.Select(0)
+}
+
+// toggleCompact switches the lists between two-line items and single-line
+// items showing only the title, and remembers the choice in the config file.
+func (m Model) toggleCompact() (tea.Model, tea.Cmd) {
+	m.config.Compact = !m.config.Compact
+	m.setDelegates()
+	// Items changed height, so the pages have to be recomputed.
+	m.resizeComponents()
+
+	if err := m.config.SaveSetting("compact", m.config.Compact); err != nil {
+		log.Printf("Error saving config: %v", err)
+	}
+	return m, nil
+}
+
+// setDelegates rebuilds the memo list delegates after a display setting changed.
+func (m *Model) setDelegates() {
+	m.list.SetDelegate(m.delegate())
+	m.trash.SetDelegate(newDelegate(m.config))
+	m.archive.SetDelegate(newDelegate(m.config))
+}
+
+func (m *Model) toggleMarked(id string) {
+	if _, ok := m.marked[id]; ok {
+		delete(m.marked, id)
+	} else {
+		m.marked[id] = struct{}{}
+	}
+}
+
+// deleteMarked moves all marked memos to the trash at once.
+func (m Model) deleteMarked() (tea.Model, tea.Cmd) {
+	now := time.Now()
+	n := 0
+	for i := range m.memos {
+		if _, ok := m.marked[m.memos[i].ID]; ok {
+			memo := m.memos[i]
+			memo.DeletedAt = &now
+			m.deleted = append(m.deleted, memo)
+			m.undoStack = append(m.undoStack, memo.ID)
+			delete(m.cursors, memo.ID)
+			continue
+		}
+		m.memos[n] = m.memos[i]
+		n++
+	}
+	m.memos = m.memos[:n]
+	clear(m.marked)
+
+	m.refreshLists()
+	return m, m.save()
+}
+
+// mergeSeparator goes between the memos joined by mergeMarked.
+const mergeSeparator = "\n\n"
+
+// mergeMarked joins the marked memos into the selected one, oldest first, and
+// moves the others to the trash, where each can be undone.
+func (m Model) mergeMarked() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+	target := item.(Memo).ID
+
+	var merged []Memo
+	for _, memo := range m.memos {
+		if _, ok := m.marked[memo.ID]; ok || memo.ID == target {
+			merged = append(merged, memo)
+		}
+	}
+	if len(merged) < 2 {
+		return m, m.setStatus("Mark another memo to merge into this one")
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].CreatedAt.Before(merged[j].CreatedAt)
+	})
+	contents := make([]string, len(merged))
+	for i, memo := range merged {
+		contents[i] = strings.TrimRight(memo.Content, "\n")
+	}
+	content := strings.Join(contents, mergeSeparator)
+
+	now := time.Now()
+	n := 0
+	for i := range m.memos {
+		memo := m.memos[i]
+		if memo.ID == target {
+			memo.Content = content
+			memo.UpdatedAt = now
+		} else if _, ok := m.marked[memo.ID]; ok {
+			memo.DeletedAt = &now
+			m.deleted = append(m.deleted, memo)
+			m.undoStack = append(m.undoStack, memo.ID)
+			delete(m.cursors, memo.ID)
+			continue
+		}
+		m.memos[n] = memo
+		n++
+	}
+	m.memos = m.memos[:n]
+	clear(m.marked)
+
+	m.refreshLists()
+	m.selectMemo(target)
+	return m, tea.Batch(m.save(), m.setStatus(fmt.Sprintf("Merged %s", plural(len(merged), "memo"))))
+}
+
+// archiveSelected moves the selected memo out of the list into the archive,
+// where it is kept until unarchived.
+func (m Model) archiveSelected() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	id := item.(Memo).ID
+	for i := range m.memos {
+		if m.memos[i].ID == id {
+			memo := m.memos[i]
+			memo.Archived = true
+			m.archived = append(m.archived, memo)
+			m.memos = append(m.memos[:i], m.memos[i+1:]...)
+			break
+		}
+	}
+
+	m.refreshLists()
+	return m, tea.Batch(m.save(), m.setStatus("Archived, press "+m.config.Keys.Archived.String()+" to view the archive"))
+}
+
+func (m Model) unarchiveSelected() (tea.Model, tea.Cmd) {
+	item := m.archive.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	id := item.(Memo).ID
+	for i := range m.archived {
+		if m.archived[i].ID == id {
+			memo := m.archived[i]
+			memo.Archived = false
+			m.memos = append(m.memos, memo)
+			m.archived = append(m.archived[:i], m.archived[i+1:]...)
+			break
+		}
+	}
+
+	m.refreshLists()
+	m.selectMemo(id)
+	return m, m.save()
+}
+
+func (m Model) openArchive() (tea.Model, tea.Cmd) {
+	m.currentMode = ViewModeArchive
+	m.archive.ResetSelected()
+	m.resizeComponents()
+	return m, nil
+}
+
+func (m Model) openReplace() (tea.Model, tea.Cmd) {
+	m.currentMode = ViewModeReplace
+	m.replaceStep = replaceFind
+	m.find.Reset()
+	m.replaceWith.Reset()
+	m.replaceWith.Blur()
+	m.resizeComponents()
+	return m, m.find.Focus()
+}
+
+func (m *Model) closeReplace() {
+	m.currentMode = ViewModeList
+	m.find.Blur()
+	m.replaceWith.Blur()
+	m.resizeComponents()
+}
+
+// countReplacements returns how many active and archived memos contain the
+// search term.
+func (m Model) countReplacements() int {
+	find := m.find.Value()
+	n := 0
+	for _, memos := range [][]Memo{m.memos, m.archived} {
+		for i := range memos {
+			if strings.Contains(memos[i].Content, find) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// replaceAll replaces the search term in every active and archived memo that
+// contains it, and saves once. Memos the replacement leaves as they were,
+// such as when the term is replaced with itself, keep their time.
+func (m Model) replaceAll() (tea.Model, tea.Cmd) {
+	find, with := m.find.Value(), m.replaceWith.Value()
+	now := time.Now()
+	n := 0
+	for _, memos := range [][]Memo{m.memos, m.archived} {
+		for i := range memos {
+			content := strings.ReplaceAll(memos[i].Content, find, with)
+			if content == memos[i].Content {
+				continue
+			}
+			memos[i].Content = content
+			memos[i].UpdatedAt = now
+			n++
+		}
+	}
+
+	m.refreshLists()
+	m.closeReplace()
+	var save tea.Cmd
+	if n > 0 {
+		save = m.save()
+	}
+	return m, tea.Batch(save, m.setStatus(fmt.Sprintf("Replaced in %s", plural(n, "memo"))))
+}
+
+func (m Model) openDue() (tea.Model, tea.Cmd) {
+	m.currentMode = ViewModeDue
+	m.due.ResetSelected()
+	m.resizeComponents()
+	return m, nil
+}
+
+// openReader shows the selected memo, rendered as Markdown, in a scrollable
+// view where it can't be edited by accident.
+func (m Model) openReader() (tea.Model, tea.Cmd) {
+	item := m.list.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+	m.reading = item.(Memo)
+	m.currentMode = ViewModeRead
+	m.resizeComponents()
+	m.reader.GotoTop()
+	return m, nil
+}
+
+// canShowHelp reports whether the help key opens the key bindings, which it
+// does wherever it is not typed or answering a question.
+func (m Model) canShowHelp() bool {
+	if m.hasFlag(flagConfirmingDelete | flagConfirmingPurge | flagConfirmingEmpty) {
+		return false
+	}
+	var l list.Model
+	switch m.currentMode {
+	case ViewModeList:
+		l = m.list
+	case ViewModeTrash:
+		l = m.trash
+	case ViewModeTags:
+		l = m.tags
+	case ViewModeTemplates:
+		l = m.picker
+	case ViewModeArchive:
+		l = m.archive
+	case ViewModeDue:
+		l = m.due
+	case ViewModeRead:
+		return true
+	default:
+		return false
+	}
+	return l.FilterState() != list.Filtering
+}
+
+// openHelp shows every key binding, grouped by where it applies.
+func (m Model) openHelp() (tea.Model, tea.Cmd) {
+	m.helpFrom = m.currentMode
+	m.currentMode = ViewModeHelp
+	m.resizeComponents()
+	m.keyHelp.GotoTop()
+	return m, nil
+}
+
+// openInfo shows where the memo file lives and how big it is. The file is
+// stat'ed once here rather than on every render.
+func (m Model) openInfo() (tea.Model, tea.Cmd) {
+	m.fileInfo = nil
+	if path, ok := backendPath(m.backend); ok {
+		info, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error reading memo file info: %v", err)
+		}
+		m.fileInfo = info
+	}
+	m.currentMode = ViewModeInfo
+	return m, nil
+}
+
+// backendPath returns the file backend keeps memos in, if it keeps them in a
+// file.
+func backendPath(backend Backend) (string, bool) {
+	switch s := backend.(type) {
+	case *Storage:
+		return s.filepath, true
+	case *SQLiteStorage:
+		return s.path, true
+	}
+	return "", false
+}
+
+func (m Model) openTrash() (tea.Model, tea.Cmd) {
+	m.currentMode = ViewModeTrash
+	m.trash.ResetSelected()
+	m.resizeComponents()
+	return m, nil
+}
+
+// openTemplates lets the user pick a template for a new memo, or starts a
+// blank memo if there are no templates.
+func (m Model) openTemplates() (tea.Model, tea.Cmd) {
+	if len(m.config.Templates) == 0 {
+		return m.createNew("")
+	}
+	m.picker.SetItems(templatesToItems(m.config.Templates))
+	m.picker.ResetSelected()
+	m.currentMode = ViewModeTemplates
+	m.resizeComponents()
+	return m, nil
+}
+
+// openDaily edits today's daily note, starting it from the "daily" template
+// if there is none yet.
+func (m Model) openDaily() (tea.Model, tea.Cmd) {
+	now := time.Now()
+	if memo, ok := findDaily(m.memos, now); ok {
+		return m.editMemo(memo)
+	}
+	template := cmp.Or(m.config.Templates["daily"], dailyTemplate)
+	model, cmd := m.createNew(expandTemplate(template, now, m.config.DateFormat))
+	m = model.(Model)
+	m.currentMemo.Daily = now.Format("2006-01-02")
+	return m, cmd
+}
+
+func (m Model) openTags() (tea.Model, tea.Cmd) {
+	m.tags.SetItems(tagsToItems(m.memos))
+	m.tags.ResetSelected()
+	m.currentMode = ViewModeTags
+	m.resizeComponents()
+	return m, nil
+}
+
+// purgeSelected permanently removes the selected memo from the trash.
+func (m Model) purgeSelected() (tea.Model, tea.Cmd) {
+	item := m.trash.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	id := item.(Memo).ID
+	m.deleted = slices.DeleteFunc(m.deleted, func(memo Memo) bool { return memo.ID == id })
+	m.refreshLists()
+	return m, m.save()
+}
+
+// emptyTrash permanently removes every deleted memo.
+func (m Model) emptyTrash() (tea.Model, tea.Cmd) {
+	n := len(m.deleted)
+	m.deleted = m.deleted[:0]
+	m.undoStack = m.undoStack[:0]
+	m.refreshLists()
+	return m, tea.Batch(m.save(), m.setStatus(fmt.Sprintf("Permanently deleted %s", plural(n, "memo"))))
+}
+
+func (m Model) restoreSelected() (tea.Model, tea.Cmd) {
+	item := m.trash.SelectedItem()
+	if item == nil {
+		return m, nil
+	}
+
+	m.restoreMemo(item.(Memo).ID)
+	return m, m.save()
+}
+
+func (m Model) undoDelete() (tea.Model, tea.Cmd) {
+	// Memos restored from the trash in the meantime leave stale IDs behind,
+	// so pop until one is actually restored.
+	for len(m.undoStack) > 0 {
+		id := m.undoStack[len(m.undoStack)-1]
+		m.undoStack = m.undoStack[:len(m.undoStack)-1]
+		if m.restoreMemo(id) {
+			return m, m.save()
+		}
+	}
+	return m, nil
+}
+
+// restoreMemo moves the deleted memo with the given ID back to the active
+// memos and reports whether it was found.
+func (m *Model) restoreMemo(id string) bool {
+	for i := range m.deleted {
+		if m.deleted[i].ID == id {
+			memo := m.deleted[i]
+			memo.DeletedAt = nil
+			m.memos = append(m.memos, memo)
+			m.deleted = append(m.deleted[:i], m.deleted[i+1:]...)
+			m.refreshLists()
+			m.selectMemo(id)
+			return true
+		}
+	}
+	return false
+}
+
+// restoreCurrent moves the memo being edited from the trash to the active
+// memos, as it is when the memo was opened from the trash.
+func (m *Model) restoreCurrent() {
+	id := m.currentMemo.ID
+	m.deleted = slices.DeleteFunc(m.deleted, func(memo Memo) bool { return memo.ID == id })
+	m.currentMemo.DeletedAt = nil
+	m.memos = append(m.memos, *m.currentMemo)
+	m.clearFlag(flagRestoring)
+}
+
+// saveAndExit stores the memo being edited and returns to the list. Only
+// changed content updates the memo's time, and nothing is saved if no memo
+// changed.
+func (m Model) saveAndExit() (tea.Model, tea.Cmd) {
+	content := trimTrailingSpace(m.textarea.Value())
+	// An unchanged memo is kept as stored, not as the textarea normalized it.
+	if !m.hasFlag(flagIsNewMemo) && !m.isModified() {
+		content = m.currentMemo.Content
+	}
+	discard := m.isBlank(content)
+	changed := true
+	var status tea.Cmd
+
+	if m.hasFlag(flagCheckDuplicate) && !discard {
+		if dup, ok := findDuplicate(m.memos, m.currentMemo.ID, content); ok {
+			m.duplicate = dup
+			m.setFlag(flagConfirmingDuplicate)
+			m.resizeComponents()
+			return m, nil
+		}
+	}
+
+	if m.hasFlag(flagRestoring) {
+		// A memo emptied in the trash stays there as it was.
+		if discard {
+			changed = false
+			status = m.setStatus("Empty memo left in the trash")
+		} else {
+			if m.currentMemo.Content != content {
+				m.currentMemo.Content = content
+				m.currentMemo.UpdatedAt = time.Now()
+			}
+			m.restoreCurrent()
+			status = m.setStatus("Memo restored")
+		}
+	} else if m.hasFlag(flagIsNewMemo) {
+		if discard {
+			changed = false
+			status = m.setStatus("Empty memo discarded")
+		} else {
+			m.currentMemo.Content = content
+			m.currentMemo.UpdatedAt = time.Now()
+			m.memos = append(m.memos, *m.currentMemo)
+		}
+	} else {
+		found := false
+		for i := range m.memos {
+			if m.memos[i].ID != m.currentMemo.ID {
+				continue
+			}
+			found = true
+			// An emptied memo goes to the trash, where it can be undone.
+			if discard {
+				now := time.Now()
+				memo := m.memos[i]
+				memo.DeletedAt = &now
+				m.deleted = append(m.deleted, memo)
+				m.undoStack = append(m.undoStack, memo.ID)
+				delete(m.cursors, memo.ID)
+				m.memos = append(m.memos[:i], m.memos[i+1:]...)
+				status = m.setStatus("Empty memo moved to trash • " + m.config.Keys.Undo.String() + " undo")
+				break
+			}
+			if m.memos[i].Content == content {
+				changed = false
+				break
+			}
+			m.memos[i].Content = content
+			m.memos[i].UpdatedAt = time.Now()
+			break
+		}
+		// The memo may have vanished in a reload while it was being edited.
+		if !found && !discard {
+			m.currentMemo.Content = content
+			m.currentMemo.UpdatedAt = time.Now()
 			m.memos = append(m.memos, *m.currentMemo)
 		}
+	}
+
+	if !discard {
+		m.cursors[m.currentMemo.ID] = m.cursorPos()
+		if changed && !m.hasFlag(flagRestoring) {
+			m.archiveOverLimit()
+		}
+	}
+	return m.closeEditor(changed, status)
+}
+
+// closeEditor goes back to the list with the current memo selected, saving
+// first if changed.
+func (m Model) closeEditor(changed bool, status tea.Cmd) (tea.Model, tea.Cmd) {
+	m.refreshLists()
+	m.restoreFilterState()
+	m.selectMemo(m.currentMemo.ID)
+
+	m.currentMode = ViewModeList
+	m.textarea.Blur()
+	m.currentMemo = nil
+	m.duplicate = Memo{}
+	m.clearFlag(flagIsNewMemo | flagRestoring | flagCheckDuplicate)
+	m.autosaveTag++
+	m.resizeComponents()
+
+	var save tea.Cmd
+	if changed {
+		save = m.save()
+	}
+	if m.hasFlag(flagQuickCapture) {
+		return m, tea.Sequence(save, tea.Quit)
+	}
+	return m, tea.Batch(save, status)
+}
+
+// findDuplicate returns an active memo other than the one with the given ID
+// whose content is the same as content, not counting differences in
+// whitespace.
+func findDuplicate(memos []Memo, id, content string) (Memo, bool) {
+	words := strings.Fields(content)
+	for _, memo := range memos {
+		if memo.ID != id && slices.Equal(strings.Fields(memo.Content), words) {
+			return memo, true
+		}
+	}
+	return Memo{}, false
+}
+
+// mergeDuplicate folds the new memo being saved into the memo it repeats,
+// which takes the new content.
+func (m Model) mergeDuplicate() (tea.Model, tea.Cmd) {
+	changed := m.dropCurrent()
+	i := slices.IndexFunc(m.memos, func(memo Memo) bool { return memo.ID == m.duplicate.ID })
+	if i < 0 {
+		// The memo it repeated is gone, so there is nothing to merge into.
+		m.clearFlag(flagCheckDuplicate)
+		return m.saveAndExit()
+	}
+
+	content := trimTrailingSpace(m.textarea.Value())
+	if m.memos[i].Content != content {
+		m.memos[i].Content = content
+		m.memos[i].UpdatedAt = time.Now()
+		changed = true
+	}
+	memo := m.memos[i]
+	m.currentMemo = &memo
+	return m.closeEditor(changed, m.setStatus(fmt.Sprintf("Merged into %q", memo.Title())))
+}
+
+// discardDuplicate drops the new memo being saved, keeping the memo it
+// repeats.
+func (m Model) discardDuplicate() (tea.Model, tea.Cmd) {
+	changed := m.dropCurrent()
+	return m.closeEditor(changed, m.setStatus("Duplicate memo discarded"))
+}
+
+// dropCurrent removes the memo being edited from the active memos, where an
+// autosave may have put it, and reports whether it was there.
+func (m *Model) dropCurrent() bool {
+	n := len(m.memos)
+	id := m.currentMemo.ID
+	m.memos = slices.DeleteFunc(m.memos, func(memo Memo) bool { return memo.ID == id })
+	return len(m.memos) != n
+}
+
+// cursorPos is a cursor position in the editor, as a line and a column in
+// runes, not counting soft wraps.
+type cursorPos struct{ row, col int }
+
+func (m Model) cursorPos() cursorPos {
+	info := m.textarea.LineInfo()
+	return cursorPos{m.textarea.Line(), info.StartColumn + info.ColumnOffset}
+}
+
+// setCursorPos moves the cursor to pos. SetValue leaves the cursor at the
+// end, so it moves up to the line, which is clamped to the lines there are.
+func (m *Model) setCursorPos(pos cursorPos) {
+	for m.textarea.Line() > pos.row {
+		line := m.textarea.Line()
+		m.textarea.CursorUp()
+		if m.textarea.Line() == line {
+			break
+		}
+	}
+	m.textarea.SetCursor(pos.col)
+}
+
+// isBlank reports whether content is only whitespace and should not be kept
+// as a memo.
+func (m Model) isBlank(content string) bool {
+	return !m.config.KeepEmpty && strings.TrimSpace(content) == ""
+}
+
+// archiveOverLimit applies max_active_memos once a new or edited memo is
+// saved. Other changes leave it alone, so a memo unarchived, restored or
+// undeleted on purpose is not archived again straight away.
+func (m *Model) archiveOverLimit() {
+	if m.config.MaxActive > 0 {
+		m.memos, m.archived = archiveOverflow(m.memos, m.archived, m.config.MaxActive)
+	}
+}
+
+// refreshLists re-sorts the memos and rebuilds the list items, applying the
+// active sort mode and tag filter to the main list. The selection follows the
+// selected memo, or stays at the same position if that memo is gone.
+func (m *Model) refreshLists() {
+	selectedID, selectedIndex := "", m.list.Index()
+	if item := m.list.SelectedItem(); item != nil {
+		selectedID = item.(Memo).ID
+	}
+
+	sortMemos(m.memos, m.sortMode)
+	sortMemosNewestFirst(m.deleted)
+	sortMemosNewestFirst(m.archived)
+
+	visible := m.memos
+	m.list.Title = "Yellow"
+	if m.notebook != defaultNotebook || len(m.notebooks) > 1 {
+		m.list.Title += " · " + m.notebook
+	}
+	if m.tagFilter != "" || m.starredOnly || m.dateRange != RangeAll {
+		since := m.dateRange.Start(time.Now())
+		visible = make([]Memo, 0, len(m.memos))
+		for i := range m.memos {
+			if m.tagFilter != "" && !m.memos[i].HasTag(m.tagFilter) {
+				continue
+			}
+			if m.starredOnly && !m.memos[i].Starred {
+				continue
+			}
+			if m.memos[i].UpdatedAt.Before(since) {
+				continue
+			}
+			visible = append(visible, m.memos[i])
+		}
+		if m.starredOnly {
+			m.list.Title += " " + m.config.StarMarker
+		}
+		if m.tagFilter != "" {
+			m.list.Title += " #" + m.tagFilter
+		}
+	}
+
+	// SetItems only re-filters asynchronously, so re-apply an active filter
+	// right away to keep the filtered view in sync with the new items.
+	filter := ""
+	if m.list.FilterState() == list.FilterApplied {
+		filter = m.list.FilterValue()
+	}
+	m.list.SetItems(memosToItems(visible))
+	if filter != "" {
+		m.list.SetFilterText(filter)
+	}
+	m.trash.SetItems(memosToItems(m.deleted))
+	m.archive.SetItems(memosToItems(m.archived))
+	m.due.SetItems(dueToItems(m.memos))
+
+	if selectedID == "" || m.selectMemo(selectedID) {
+		return
+	}
+	if n := len(m.list.VisibleItems()); n > 0 {
+		m.list.Select(min(selectedIndex, n-1))
+	}
+}
+
+// autosave stores the textarea content without leaving edit mode. A new memo
+// becomes a regular one once it has been autosaved.
+func (m Model) autosave() (tea.Model, tea.Cmd) {
+	cmd := m.storeCurrent()
+	return m, tea.Batch(cmd, m.autosaveTick())
+}
+
+// storeCurrent saves the memo being edited without leaving the editor. It
+// does nothing if the memo is unchanged or blank.
+func (m *Model) storeCurrent() tea.Cmd {
+	content := trimTrailingSpace(m.textarea.Value())
+	if !m.isModified() || m.isBlank(content) {
+		return nil
+	}
+	m.original = m.textarea.Value()
+
+	m.currentMemo.Content = content
+	m.currentMemo.UpdatedAt = time.Now()
+	if m.hasFlag(flagRestoring) {
+		m.restoreCurrent()
+	} else if m.hasFlag(flagIsNewMemo) {
+		m.memos = append(m.memos, *m.currentMemo)
+		m.clearFlag(flagIsNewMemo)
+		m.archiveOverLimit()
 	} else {
 		for i := range m.memos {
 			if m.memos[i].ID == m.currentMemo.ID {
-				m.memos[i].Content = content
-				m.memos[i].UpdatedAt = time.Now()
+				m.memos[i] = *m.currentMemo
 				break
 			}
 		}
+		m.archiveOverLimit()
 	}
 
-	sortMemosNewestFirst(m.memos)
-	m.list.SetItems(memosToItems(m.memos))
-	m.restoreFilterState()
+	m.refreshLists()
+	return m.save()
+}
 
-	m.currentMode = ViewModeList
-	m.textarea.Blur()
-	m.currentMemo = nil
-	m.clearFlag(flagIsNewMemo)
-	m.resizeComponents()
+// quitEditing keeps the memo being edited and quits. The save is left pending
+// rather than run as a command, which could be cut short by the quit; main
+// flushes it once the program has stopped.
+func (m Model) quitEditing() (tea.Model, tea.Cmd) {
+	m.storeCurrent()
+	return m, tea.Quit
+}
+
+func (m Model) autosaveTick() tea.Cmd {
+	if m.config.AutosaveSeconds <= 0 {
+		return nil
+	}
+
+	tag := m.autosaveTag
+	return tea.Tick(time.Duration(m.config.AutosaveSeconds)*time.Second, func(time.Time) tea.Msg {
+		return autosaveMsg{tag}
+	})
+}
 
-	return m, saveMemos(m.storage, &MemoData{
-		Active:  m.memos,
-		Deleted: m.deleted,
+// setStatus shows text in the help line until it is cleared a few seconds
+// later by the returned command.
+func (m *Model) setStatus(text string) tea.Cmd {
+	m.status = text
+	m.statusTag++
+	tag := m.statusTag
+	return tea.Tick(3*time.Second, func(time.Time) tea.Msg {
+		return clearStatusMsg{tag}
 	})
 }
 
+// corruptFileStatus reports an unreadable memo file. The message stays up
+// until the next status replaces it, since it explains why memos are missing.
+// On a reload the current memos are kept.
+func (m *Model) corruptFileStatus(err *CorruptFileError, reload bool) {
+	msg := "Memo file is unreadable, starting empty"
+	if reload {
+		msg = "Reload failed: memo file is unreadable"
+	}
+	if err.Backup != "" {
+		msg += " • a copy was saved to " + err.Backup
+	}
+	m.status = msg
+	m.statusTag++
+}
+
 func (m *Model) saveFilterState() {
 	if m.list.FilterState() == list.FilterApplied {
 		m.setFlag(flagWasFiltered)
@@ -383,6 +4648,14 @@ func (m *Model) saveFilterState() {
 	}
 }
 
+// delegate returns the main list delegate, which shows marked memos.
+func (m Model) delegate() memoDelegate {
+	d := newDelegate(m.config)
+	d.marked = m.marked
+	return d
+}
+
+// restoreFilterState re-applies the filter saved when editing started.
 func (m *Model) restoreFilterState() {
 	if m.hasFlag(flagWasFiltered) && m.savedFilterValue != "" {
 		m.list.SetFilterText(m.savedFilterValue)
@@ -391,6 +4664,18 @@ func (m *Model) restoreFilterState() {
 	}
 }
 
+// selectMemo moves the list cursor to the visible memo with the given ID and
+// reports whether it was found.
+func (m *Model) selectMemo(id string) bool {
+	for i, item := range m.list.VisibleItems() {
+		if item.(Memo).ID == id {
+			m.list.Select(i)
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Model) resizeComponents() {
 	if m.width == 0 || m.height == 0 {
 		return
@@ -399,91 +4684,840 @@ func (m *Model) resizeComponents() {
 	vm, hm := appStyle.GetFrameSize()
 	helpHeight := lipgloss.Height(m.helpView())
 
-	if m.currentMode == ViewModeList {
-		m.list.SetSize(m.width-hm, m.height-vm-helpHeight)
-	} else {
+	// Tiny windows leave no room for the content, so sizes are clamped to
+	// zero rather than handing negative sizes to the components.
+	width := max(m.width-hm, 0)
+	height := max(m.height-vm-helpHeight, 0)
+
+	switch m.currentMode {
+	case ViewModeList:
+		statusHeight := lipgloss.Height(m.statusBarView())
+		if m.previewVisible() {
+			width /= 2
+		}
+		m.list.SetSize(width, max(height-statusHeight, 0))
+	case ViewModeTrash:
+		m.trash.SetSize(width, height)
+	case ViewModeTags:
+		m.tags.SetSize(width, height)
+	case ViewModeTemplates:
+		m.picker.SetSize(width, height)
+	case ViewModeArchive:
+		m.archive.SetSize(width, height)
+	case ViewModeDue:
+		m.due.SetSize(width, height)
+	case ViewModeInfo:
+	case ViewModeHelp:
+		m.keyHelp.Width = width
+		m.keyHelp.Height = max(height-lipgloss.Height(keyHelpTitle), 0)
+		m.keyHelp.SetContent(m.keyBindingsView())
+	case ViewModeRead:
+		titleHeight := lipgloss.Height(m.readerTitleView())
+		m.reader.Width = width
+		m.reader.Height = max(height-titleHeight, 0)
+		m.reader.SetContent(renderMarkdown(m.reading.Content, width))
+	case ViewModeReplace:
+		m.find.Width = max(width-lipgloss.Width(m.find.Prompt)-1, 0)
+		m.replaceWith.Width = max(width-lipgloss.Width(m.replaceWith.Prompt)-1, 0)
+	default:
 		titleHeight := lipgloss.Height(m.titleView())
-		m.textarea.SetWidth(m.width - hm - 4)
-		m.textarea.SetHeight(m.height - vm - titleHeight - helpHeight)
+		m.textarea.SetWidth(max(m.width-hm-4, 0))
+		m.textarea.SetHeight(max(m.height-vm-titleHeight-helpHeight, 0))
+		// Resizing keeps the cursor where it was but not the scroll offset,
+		// so scroll the cursor back into view. A nil message does nothing
+		// else.
+		m.textarea, _ = m.textarea.Update(nil)
 	}
 }
 
 // View ------------------------------------------------------------------------
 
 func (m Model) View() string {
-	if m.currentMode == ViewModeList {
+	switch m.currentMode {
+	case ViewModeList:
+		body := m.list.View()
+		if len(m.memos) == 0 && m.list.FilterState() == list.Unfiltered {
+			body = m.emptyView()
+		}
+		if m.previewVisible() {
+			body = lipgloss.JoinHorizontal(lipgloss.Top,
+				lipgloss.NewStyle().Width(m.list.Width()).Render(body), m.previewView())
+		}
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, body, m.statusBarView(), m.helpView()),
+		)
+	case ViewModeTrash:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, m.trash.View(), m.helpView()),
+		)
+	case ViewModeTags:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, m.tags.View(), m.helpView()),
+		)
+	case ViewModeTemplates:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, m.picker.View(), m.helpView()),
+		)
+	case ViewModeArchive:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, m.archive.View(), m.helpView()),
+		)
+	case ViewModeDue:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, m.due.View(), m.helpView()),
+		)
+	case ViewModeInfo:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, titleStyle.Render("Memo file"), "", m.infoView(), m.helpView()),
+		)
+	case ViewModeRead:
 		return appStyle.Render(
-			lipgloss.JoinVertical(lipgloss.Left, m.list.View(), m.helpView()),
+			lipgloss.JoinVertical(lipgloss.Left, m.readerTitleView(), m.reader.View(), m.helpView()),
 		)
+	case ViewModeHelp:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left, titleStyle.Render(keyHelpTitle)+"\n", m.keyHelp.View(), m.helpView()),
+		)
+	case ViewModeReplace:
+		return appStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left,
+				titleStyle.Render("Find and replace"), "", m.find.View(), m.replaceWith.View(), m.helpView()),
+		)
+	}
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left, m.titleView(), m.textarea.View(), m.helpView()),
+	)
+}
+
+const keyHelpTitle = "Key bindings"
+
+// keyBinding is a line of the key bindings view.
+type keyBinding struct{ keys, action string }
+
+// keyBindingsView lists every key binding, grouped by the view it applies to,
+// with the keys in the primary color.
+func (m Model) keyBindingsView() string {
+	k := m.config.Keys
+	back := func(keys Keys) string { return "esc/" + keys.All() }
+	groups := []struct {
+		name     string
+		bindings []keyBinding
+	}{
+		{"List", []keyBinding{
+			{k.New.All(), "new memo"},
+			{k.Template.All(), "new memo from a template"},
+			{k.Daily.All(), "today's daily note"},
+			{k.Edit.All(), "edit"},
+			{k.Read.All(), "read"},
+			{k.Editor.All(), "edit in $EDITOR"},
+			{k.Duplicate.All(), "duplicate"},
+			{k.Delete.All(), "move to trash"},
+			{k.Undo.All(), "undo delete"},
+			{k.Mark.All(), "mark, to delete or merge several memos"},
+			{k.Merge.All(), "merge marked memos into the selected one"},
+			{k.Copy.All(), "copy to the clipboard"},
+			{k.Write.All(), "write to a file"},
+			{k.Archive.All(), "archive"},
+			{k.Star.All(), "star"},
+			{"↑/k ↓/j", "move"},
+			{"←/h →/l", "page"},
+			{k.Top.All() + " " + k.Bottom.All(), "top, bottom"},
+			{"/", "filter"},
+			{k.Starred.All(), "starred memos only"},
+			{k.DateRange.All(), "memos updated today, this week or this month"},
+			{k.Tags.All(), "tags"},
+			{k.Due.All(), "due memos"},
+			{k.Archived.All(), "archived memos"},
+			{k.Trash.All(), "trash"},
+			{"esc", "show all memos, clear marks"},
+			{k.Sort.All(), "sort order"},
+			{k.Timestamp.All(), "created or updated time"},
+			{k.Compact.All(), "compact list"},
+			{k.Preview.All(), "preview pane"},
+			{k.Focus.All(), "scroll the preview pane"},
+			{k.Notebook.All(), "next notebook"},
+			{k.Info.All(), "memo file info"},
+			{k.Reload.All(), "reload from disk"},
+			{k.Replace.All(), "find and replace"},
+			{k.Help.All(), "key bindings"},
+			{k.Quit.All() + " ctrl+c", "quit"},
+		}},
+		{"Filtered list", []keyBinding{
+			{k.Edit.All(), "edit"},
+			{k.Export.All(), "export the shown memos"},
+			{"esc", "clear filter"},
+		}},
+		{"Editor", []keyBinding{
+			{k.Save.All(), "save and close"},
+			{k.LineNumbers.All(), "line numbers"},
+			{k.Clear.All(), "clear, again to undo"},
+			{k.Date.All(), "insert the date"},
+			{"ctrl+c", "quit"},
+		}},
+		{"Vim mode", []keyBinding{
+			{"i a A", "insert"},
+			{"o O", "new line below, above"},
+			{"h j k l", "move"},
+			{"0 $", "line start, end"},
+			{"x", "delete a character"},
+			{"dd", "delete the line"},
+			{":w", "save"},
+			{"esc :q :wq", "save and close"},
+		}},
+		{"Reader and preview pane", []keyBinding{
+			{"↑/k ↓/j", "scroll"},
+			{"PgUp/PgDn", "page"},
+			{k.Edit.All(), "edit, in the reader"},
+			{back(k.Read), "back, from the reader"},
+			{back(k.Focus), "back to the list, from the preview pane"},
+		}},
+		{"Trash", []keyBinding{
+			{"enter/r", "restore"},
+			{"e", "edit and restore"},
+			{"x", "delete forever"},
+			{"X", "empty the trash"},
+			{back(k.Trash), "back"},
+		}},
+		{"Archive", []keyBinding{
+			{"enter/" + k.Archive.All(), "unarchive"},
+			{back(k.Archived), "back"},
+		}},
+		{"Tags, due memos and templates", []keyBinding{
+			{"enter", "filter by tag, edit, new memo"},
+			{back(k.Tags), "back from tags"},
+			{back(k.Due), "back from due memos"},
+			{back(k.Template), "back from templates"},
+		}},
+	}
+
+	width := 0
+	for _, g := range groups {
+		for _, b := range g.bindings {
+			width = max(width, lipgloss.Width(b.keys))
+		}
+	}
+	keyStyle := lipgloss.NewStyle().Foreground(colorPrimary).Width(width + 2)
+	actionStyle := lipgloss.NewStyle().Foreground(colorText)
+
+	var out []string
+	for i, g := range groups {
+		if i > 0 {
+			out = append(out, "")
+		}
+		out = append(out, headingStyle.Render(g.name))
+		for _, b := range g.bindings {
+			out = append(out, keyStyle.Render(b.keys)+actionStyle.Render(b.action))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// emptyView stands in for the list while there are no memos, to show new
+// users how to write one.
+func (m Model) emptyView() string {
+	title := m.list.Styles.TitleBar.Render(m.list.Styles.Title.Render(m.list.Title))
+	hint := emptyStyle.Render(fmt.Sprintf("No memos yet. Press %s to create your first one.", m.config.Keys.New))
+	return lipgloss.NewStyle().Height(m.list.Height()).Render(lipgloss.JoinVertical(lipgloss.Left, title, hint))
+}
+
+// readerTitleView names the memo being read, with a blank line below it.
+func (m Model) readerTitleView() string {
+	return titleStyle.Render(m.reading.Title()) + "\n"
+}
+
+// infoView lists the memo file's path, size and modification time, and what
+// it holds.
+func (m Model) infoView() string {
+	size, saved := "not written yet", "never"
+	if m.fileInfo != nil {
+		size = formatSize(m.fileInfo.Size())
+		saved = m.fileInfo.ModTime().Format(timeFormat) + " (" + RelativeTime(m.fileInfo.ModTime()) + ")"
+	}
+	path, encrypted := "in memory", "no"
+	if p, ok := backendPath(m.backend); ok {
+		path = p
+	}
+	if s, ok := m.backend.(*Storage); ok && s.passphrase != "" {
+		encrypted = "yes"
+	}
+
+	rows := [][2]string{
+		{"Path", path},
+		{"Size", size},
+		{"Modified", saved},
+		{"Memos", fmt.Sprintf("%d active · %d in trash · %d archived", len(m.memos), len(m.deleted), len(m.archived))},
+		{"Notebook", fmt.Sprintf("%s (%s)", m.notebook, plural(max(len(m.notebooks), 1), "notebook"))},
+		{"Encrypted", encrypted},
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = fmt.Sprintf("%-10s %s", row[0], row[1])
+	}
+	return previewStyle.Render(strings.Join(lines, "\n"))
+}
+
+// minPreviewWidth is the narrowest window that still fits the preview pane
+// beside the list.
+const minPreviewWidth = 60
+
+// previewVisible reports whether the preview pane is toggled on and the window
+// is wide enough to split.
+func (m Model) previewVisible() bool {
+	hm, _ := appStyle.GetFrameSize()
+	return m.hasFlag(flagShowPreview) && m.width-hm >= minPreviewWidth
+}
+
+// previewPane returns the preview viewport sized to the space the list leaves
+// free and showing the selected memo as Markdown. It starts at the top for
+// any memo other than the one last scrolled.
+func (m Model) previewPane() viewport.Model {
+	hm, _ := appStyle.GetFrameSize()
+	vp := m.preview
+	vp.Width = max(m.width-hm-m.list.Width()-previewStyle.GetHorizontalFrameSize(), 0)
+	vp.Height = m.list.Height()
+
+	content, id := "No memo selected", ""
+	if item := m.list.SelectedItem(); item != nil {
+		content, id = renderMarkdown(item.(Memo).Content, vp.Width), item.(Memo).ID
+	}
+	vp.SetContent(content)
+	if id != m.previewFor {
+		vp.GotoTop()
+	}
+	return vp
+}
+
+// previewView renders the preview pane, with its border in the primary color
+// while it has focus.
+func (m Model) previewView() string {
+	vp := m.previewPane()
+	style := previewStyle
+	if m.previewFocused {
+		style = style.BorderForeground(colorPrimary)
+	}
+	return style.Render(vp.View())
+}
+
+// statusBarView shows how many memos there are in the list view, and which
+// page is showing once they no longer fit on one.
+func (m Model) statusBarView() string {
+	status := fmt.Sprintf("%d active · %d in trash", len(m.memos), len(m.deleted))
+	if len(m.archived) > 0 {
+		status += fmt.Sprintf(" · %d archived", len(m.archived))
+	}
+	if m.dateRange != RangeAll {
+		status += fmt.Sprintf(" · %d updated %s", len(m.list.Items()), m.dateRange)
+	}
+	if pages := m.list.Paginator.TotalPages; pages > 1 {
+		status += fmt.Sprintf(" · page %d/%d", m.list.Paginator.Page+1, pages)
+	}
+	if save := m.saveView(); save != "" {
+		status += " · " + save
+	}
+	return statusBarStyle.Render(status)
+}
+
+// saveView reports the outcome of the last save, or nothing once a
+// successful save has been shown for a moment.
+func (m Model) saveView() string {
+	if m.saveErr != nil {
+		return warningStyle.Render("Unsaved changes")
+	}
+	if m.saved {
+		return "Saved ✓"
+	}
+	return ""
+}
+
+func (m Model) titleView() string {
+	title := "Edit Memo"
+	if m.hasFlag(flagIsNewMemo) {
+		title = "New Memo"
+	} else if m.hasFlag(flagRestoring) {
+		title = "Edit Deleted Memo"
+	}
+	if m.isModified() {
+		title += " •"
+	}
+	title = editTitleStyle.Render(title)
+	if save := m.saveView(); save != "" {
+		title = lipgloss.JoinHorizontal(lipgloss.Top, title, statusBarStyle.Render(save))
+	}
+	return title
+}
+
+// isModified reports whether the textarea differs from the stored memo.
+func (m Model) isModified() bool {
+	if m.currentMemo == nil {
+		return false
+	}
+	if m.hasFlag(flagIsNewMemo) {
+		return m.textarea.Value() != ""
+	}
+	return m.textarea.Value() != m.original
+}
+
+func (m Model) helpView() string {
+	if m.hasFlag(flagConfirmingDelete) {
+		if len(m.marked) > 0 {
+			return helpStyle.Render(fmt.Sprintf("Delete %d marked memos? y: yes • any other key: cancel", len(m.marked)))
+		}
+		if item := m.list.SelectedItem(); item != nil {
+			return helpStyle.Render(fmt.Sprintf("Delete %q? y: yes • any other key: cancel", item.(Memo).Title()))
+		}
+	}
+
+	if m.hasFlag(flagConfirmingEmpty) {
+		return helpStyle.Render(fmt.Sprintf("Permanently delete %s in the trash? y: yes • any other key: cancel", plural(len(m.deleted), "memo")))
+	}
+	if m.hasFlag(flagConfirmingPurge) {
+		if item := m.trash.SelectedItem(); item != nil {
+			return helpStyle.Render(fmt.Sprintf("Permanently delete %q? y: yes • any other key: cancel", item.(Memo).Title()))
+		}
+	}
+
+	if m.hasFlag(flagConfirmingDuplicate) {
+		return helpStyle.Render(fmt.Sprintf("Same as %q. k: keep both • m: merge into it • d: discard • any other key: keep editing", m.duplicate.Title()))
+	}
+
+	if m.status != "" {
+		return helpStyle.Render(m.status)
+	}
+
+	if m.currentMode == ViewModeList && m.previewFocused && m.previewVisible() {
+		k := m.config.Keys
+		return helpStyle.Render(fmt.Sprintf("↑/k up • ↓/j down • PgUp/PgDn page • %.0f%% • Esc/%s: back to list • %s hide preview • %s quit",
+			m.previewPane().ScrollPercent()*100, k.Focus, k.Preview, k.Quit))
+	}
+
+	if m.currentMode == ViewModeList {
+		filterState := m.list.FilterState()
+
+		switch filterState {
+		case list.Filtering:
+			// The list re-filters after every keystroke, so this follows
+			// the query as it is typed.
+			matches := fmt.Sprintf("%d matches", len(m.list.VisibleItems()))
+			if len(m.list.VisibleItems()) == 1 {
+				matches = "1 match"
+			}
+			return helpStyle.Render(matches + " • Esc: cancel filter")
+		case list.FilterApplied:
+			return helpStyle.Render(fmt.Sprintf("%s: edit • %s export shown memos • Esc: return to list view", m.config.Keys.Edit, m.config.Keys.Export))
+		default:
+			k := m.config.Keys
+			if len(m.marked) > 0 {
+				return helpStyle.Render(fmt.Sprintf("%d marked • %s mark/unmark • %s: delete marked • %s merge into selected • Esc: clear marks", len(m.marked), k.Mark, k.Delete, k.Merge))
+			}
+			if m.tagFilter != "" || m.starredOnly || m.dateRange != RangeAll {
+				return helpStyle.Render(fmt.Sprintf("%s: new • %s: edit • %s: delete • %s star • %s tags • %s updated: %s • %s sort: %s • Esc: show all • %s quit",
+					k.New, k.Edit, k.Delete, k.Star, k.Tags, k.DateRange, m.dateRange, k.Sort, m.sortMode, k.Quit))
+			}
+			if len(m.memos) > 0 {
+				return helpStyle.Render(fmt.Sprintf("%s: new • %s: edit • %s: delete • %s star • / filter • %s tags • %s sort: %s • %s all keys • %s quit",
+					k.New, k.Edit, k.Delete, k.Star, k.Tags, k.Sort, m.sortMode, k.Help, k.Quit))
+			}
+			return helpStyle.Render(fmt.Sprintf("%s: new • %s undo • %s archived • %s trash • %s all keys • %s quit", k.New, k.Undo, k.Archived, k.Trash, k.Help, k.Quit))
+		}
+	}
+	k := m.config.Keys
+	if m.currentMode == ViewModeDue {
+		if len(m.due.Items()) > 0 {
+			return helpStyle.Render(fmt.Sprintf("Enter: edit • ↑/k up • ↓/j down • Esc/%s: back • %s quit", k.Due, k.Quit))
+		}
+		return helpStyle.Render(fmt.Sprintf("No memos with due:YYYY-MM-DD yet • Esc/%s: back • %s quit", k.Due, k.Quit))
+	}
+	if m.currentMode == ViewModeInfo {
+		return helpStyle.Render(fmt.Sprintf("Any key: back • %s quit", k.Quit))
+	}
+	if m.currentMode == ViewModeHelp {
+		return helpStyle.Render(fmt.Sprintf("↑/k up • ↓/j down • PgUp/PgDn page • %.0f%% • Esc/%s: back • ctrl+c quit",
+			m.keyHelp.ScrollPercent()*100, k.Help))
+	}
+	if m.currentMode == ViewModeRead {
+		return helpStyle.Render(fmt.Sprintf("↑/k up • ↓/j down • PgUp/PgDn page • %.0f%% • %s: edit • Esc/%s: back • %s quit",
+			m.reader.ScrollPercent()*100, k.Edit, k.Read, k.Quit))
+	}
+	if m.currentMode == ViewModeReplace {
+		switch m.replaceStep {
+		case replaceFind:
+			return helpStyle.Render("Enter: next • Esc: cancel")
+		case replaceWith:
+			return helpStyle.Render("Enter: preview • Esc: cancel")
+		}
+		n := m.countReplacements()
+		if n == 0 {
+			return helpStyle.Render(fmt.Sprintf("No memos contain %q • any key: back", m.find.Value()))
+		}
+		return helpStyle.Render(fmt.Sprintf("Replace %q with %q in %s? y: yes • any other key: cancel",
+			m.find.Value(), m.replaceWith.Value(), plural(n, "memo")))
+	}
+	if m.currentMode == ViewModeTemplates {
+		return helpStyle.Render(fmt.Sprintf("Enter: new memo from template • ↑/k up • ↓/j down • Esc/%s: back • %s quit", k.Template, k.Quit))
+	}
+	if m.currentMode == ViewModeTags {
+		if len(m.tags.Items()) > 0 {
+			return helpStyle.Render(fmt.Sprintf("Enter: filter by tag • ↑/k up • ↓/j down • Esc/%s: back • %s quit", k.Tags, k.Quit))
+		}
+		return helpStyle.Render(fmt.Sprintf("No #tags yet • Esc/%s: back • %s quit", k.Tags, k.Quit))
+	}
+	if m.currentMode == ViewModeArchive {
+		if len(m.archived) > 0 {
+			return helpStyle.Render(fmt.Sprintf("Enter/%s: unarchive • ↑/k up • ↓/j down • Esc/%s: back • %s quit", k.Archive, k.Archived, k.Quit))
+		}
+		return helpStyle.Render(fmt.Sprintf("Esc/%s: back • %s quit", k.Archived, k.Quit))
+	}
+	if m.currentMode == ViewModeTrash {
+		if len(m.deleted) > 0 {
+			return helpStyle.Render(fmt.Sprintf("Enter/r: restore • e edit and restore • x delete forever • X empty trash • ↑/k up • ↓/j down • Esc/%s: back • %s quit", k.Trash, k.Quit))
+		}
+		return helpStyle.Render(fmt.Sprintf("Esc/%s: back • %s quit", k.Trash, k.Quit))
+	}
+	keys := k.Save.String() + ": save changes • " + k.LineNumbers.String() + " line numbers • " + k.Clear.String() + " clear • " + k.Date.String() + " insert date"
+	if m.config.VimMode {
+		switch m.vim.mode {
+		case vimInsert:
+			keys = "-- INSERT -- • " + k.Save.String() + ": normal mode"
+		case vimNormal:
+			keys = "-- NORMAL -- • i insert • o new line • dd delete line • :w save • Esc/:q save and exit"
+		case vimCommand:
+			return helpStyle.Render(":" + m.vim.command)
+		}
+	}
+
+	content := m.textarea.Value()
+	chars := utf8.RuneCountInString(content)
+	if m.textarea.CharLimit > 0 {
+		return helpStyle.Render(fmt.Sprintf("%s • %d words • %d chars • %d left",
+			keys, countWords(content), chars, max(m.textarea.CharLimit-m.textarea.Length(), 0)))
+	}
+	return helpStyle.Render(fmt.Sprintf("%s • %d words • %d chars",
+		keys, countWords(content), chars))
+}
+
+func (m Model) save() tea.Cmd {
+	// The entry for the open notebook is stale; its memos live in the model.
+	data := &MemoData{Notebooks: make(map[string]*Notebook, len(m.notebooks)+1)}
+	for name, nb := range m.notebooks {
+		data.Notebooks[name] = nb
+	}
+	data.Notebooks[m.notebook] = &Notebook{Active: m.memos, Deleted: m.deleted, Archived: m.archived}
+	return saveMemos(m.saver, data, m.config.SaveDelay())
+}
+
+// Flush writes changes still waiting to be saved. The model's copies share
+// one Saver, so the model passed to tea.NewProgram can flush after it quits.
+func (m Model) Flush() error {
+	return m.saver.Flush()
+}
+
+func loadMemos(s *Saver) tea.Cmd {
+	return func() tea.Msg {
+		data, err := s.Load()
+		return loadMemosMsg{data: data, err: err}
+	}
+}
+
+func reloadMemos(s *Saver) tea.Cmd {
+	return func() tea.Msg {
+		data, err := s.Load()
+		return loadMemosMsg{data: data, err: err, reload: true}
+	}
+}
+
+// saveMemos snapshots data right away, since the model keeps mutating its
+// slices while the save runs in the background. With a delay, the write waits
+// that long and is dropped if another save was requested in the meantime, so
+// a burst of changes is written once. Model.Flush writes whatever is still
+// waiting when the program quits.
+func saveMemos(s *Saver, data *MemoData, delay time.Duration) tea.Cmd {
+	snapshot := data.clone()
+	version := s.nextVersion(snapshot)
+	if delay <= 0 {
+		return func() tea.Msg {
+			return saveWithRetry(s, version, snapshot)
+		}
+	}
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		if s.superseded(version) {
+			return nil
+		}
+		return saveWithRetry(s, version, snapshot)
+	})
+}
+
+// saveRetries bounds how often a save that failed for a possibly temporary
+// reason is tried again, waiting saveBackoff and then twice as long each time.
+const (
+	saveRetries = 3
+	saveBackoff = 250 * time.Millisecond
+)
+
+// saveWithRetry writes a snapshot, retrying transient failures. It gives up
+// quietly once a newer save has been requested, since that save reports its
+// own outcome.
+func saveWithRetry(s *Saver, version uint64, data *MemoData) tea.Msg {
+	wait := saveBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.saveVersion(version, data)
+		if err == nil || !isTransient(err) || attempt == saveRetries {
+			return saveCompleteMsg{err}
+		}
+		log.Printf("Warning: save failed, retrying in %v: %v", wait, err)
+		time.Sleep(wait)
+		wait *= 2
+		if s.superseded(version) {
+			return nil
+		}
+	}
+}
+
+// isTransient reports whether a failed write might succeed if tried again,
+// such as a full disk or a file briefly locked by another program. A missing
+// directory or a bad path is not.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EACCES, syscall.ENOSPC, syscall.EAGAIN, syscall.EBUSY, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// UI --------------------------------------------------------------------------
+
+var (
+	colorPrimary    lipgloss.Color
+	colorLineNumber lipgloss.Color
+	colorText       lipgloss.Color
+	colorMuted      lipgloss.Color
+	colorBackground lipgloss.Color
+	colorEndBuffer  lipgloss.Color
+	colorWarning    lipgloss.Color
+	colorStale      lipgloss.Color
+
+	appStyle = lipgloss.NewStyle().Padding(1, 2)
+
+	titleStyle     lipgloss.Style
+	editTitleStyle lipgloss.Style
+	helpStyle      lipgloss.Style
+	statusBarStyle lipgloss.Style
+	warningStyle   lipgloss.Style
+	matchStyle     lipgloss.Style
+	previewStyle   lipgloss.Style
+	headingStyle   lipgloss.Style
+	quoteStyle     lipgloss.Style
+	codeStyle      lipgloss.Style
+	emptyStyle     lipgloss.Style
+)
+
+func init() { applyTheme(DefaultTheme()) }
+
+// applyTheme sets the colors and rebuilds the styles that use them. Components
+// pick up colors when they are constructed, so this must run before
+// InitialModel.
+func applyTheme(t Theme) {
+	colorPrimary = lipgloss.Color(t.Primary)
+	colorLineNumber = lipgloss.Color(t.LineNumber)
+	colorText = lipgloss.Color(t.Text)
+	colorMuted = lipgloss.Color(t.Muted)
+	colorBackground = lipgloss.Color(t.Background)
+	colorEndBuffer = lipgloss.Color(t.EndBuffer)
+	colorWarning = lipgloss.Color(t.Warning)
+	colorStale = lipgloss.Color(t.Stale)
+
+	titleStyle = lipgloss.NewStyle().Bold(true).Foreground(colorPrimary)
+
+	editTitleStyle = lipgloss.NewStyle().
+		Bold(true).
+		Foreground(colorPrimary).
+		PaddingLeft(2).
+		PaddingBottom(1)
+
+	helpStyle = lipgloss.NewStyle().Foreground(colorMuted).MarginTop(1)
+
+	statusBarStyle = lipgloss.NewStyle().Foreground(colorMuted).PaddingLeft(2)
+	warningStyle = lipgloss.NewStyle().Foreground(colorWarning)
+	matchStyle = lipgloss.NewStyle().Foreground(colorPrimary).Underline(true).Bold(true)
+
+	previewStyle = lipgloss.NewStyle().
+		Foreground(colorText).
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(colorMuted).
+		PaddingLeft(2)
+	headingStyle = lipgloss.NewStyle().Bold(true).Foreground(colorPrimary)
+	quoteStyle = lipgloss.NewStyle().Italic(true).Foreground(colorMuted)
+	codeStyle = lipgloss.NewStyle().Foreground(colorLineNumber)
+	emptyStyle = lipgloss.NewStyle().Foreground(colorMuted).PaddingLeft(2)
+}
+
+// renderMarkdown styles the parts of Markdown that matter in a short memo:
+// headings, list items, quotes and code blocks. Everything else is wrapped to
+// width as plain text.
+func renderMarkdown(src string, width int) string {
+	wrap := lipgloss.NewStyle().Width(max(width, 1))
+	var out []string
+	inCode := false
+
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+
+		heading, isHeading := markdownHeading(trimmed)
+		switch {
+		case inCode:
+			out = append(out, codeStyle.Render(line))
+		case isHeading:
+			out = append(out, wrap.Render(headingStyle.Render(heading)))
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "), strings.HasPrefix(trimmed, "+ "):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			out = append(out, wrap.Render(indent+"• "+trimmed[2:]))
+		case strings.HasPrefix(trimmed, ">"):
+			quote := strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+			out = append(out, wrap.Render(quoteStyle.Render("│ "+quote)))
+		default:
+			out = append(out, wrap.Render(line))
+		}
 	}
-	return appStyle.Render(
-		lipgloss.JoinVertical(lipgloss.Left, m.titleView(), m.textarea.View(), m.helpView()),
-	)
+	return strings.Join(out, "\n")
 }
 
-func (m Model) titleView() string {
-	title := "Edit Memo"
-	if m.hasFlag(flagIsNewMemo) {
-		title = "New Memo"
-	}
-	return editTitleStyle.Render(title)
+// memoDelegate renders memos with a labelled created or updated timestamp as
+// their description, colored by how long ago the memo was updated, and a check
+// mark in front of marked memos and a star in front of starred ones.
+type memoDelegate struct {
+	list.DefaultDelegate
+	showCreated bool
+	relative    bool
+	marked      map[string]struct{}
+	mutedAfter  time.Duration
+	staleAfter  time.Duration
+	starMarker  string
 }
 
-func (m Model) helpView() string {
-	if m.currentMode == ViewModeList {
-		filterState := m.list.FilterState()
+// Render draws memos itself rather than through the default delegate, which
+// would style the title rune by rune while filtering and so break up the
+// colored markers.
+func (d memoDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	memo, ok := item.(Memo)
+	if !ok || m.Width() <= 0 {
+		d.DefaultDelegate.Render(w, m, index, item)
+		return
+	}
 
-		switch filterState {
-		case list.Filtering:
-			return helpStyle.Render("Esc: cancel filter")
-		case list.FilterApplied:
-			return helpStyle.Render("Enter: edit • Esc: return to list view")
-		default:
-			if len(m.memos) > 0 {
-				return helpStyle.Render("Tab: new • Enter: edit • Delete: delete • ↑/k up • ↓/j down • / filter • q quit")
-			}
-			return helpStyle.Render("Tab: new • q quit")
+	s := d.Styles
+	titleStyle := s.NormalTitle
+	descStyle := s.NormalDesc.Foreground(d.ageColor(memo.UpdatedAt))
+	switch {
+	case m.FilterState() == list.Filtering && m.FilterValue() == "":
+		titleStyle, descStyle = s.DimmedTitle, s.DimmedDesc
+	case index == m.Index() && m.FilterState() != list.Filtering:
+		titleStyle, descStyle = s.SelectedTitle, s.SelectedDesc
+	}
+
+	label, t := "updated ", memo.UpdatedAt
+	if d.showCreated {
+		label, t = "created ", memo.CreatedAt
+	}
+	desc := label + t.Format(timeFormat)
+	if d.relative {
+		desc = label + RelativeTime(t)
+	}
+	if m.FilterState() != list.Unfiltered && m.FilterValue() != "" {
+		// Matches are positions in the whole memo, so they are shown in a
+		// snippet of the content rather than on the title.
+		if snippet, runes := matchSnippet(memo.Content, m.MatchesForItem(index), m.Width()-4); snippet != "" {
+			unmatched := descStyle.Inline(true)
+			desc = lipgloss.StyleRunes(snippet, runes, unmatched.Inherit(matchStyle), unmatched)
 		}
 	}
-	return helpStyle.Render("Esc: save changes")
+
+	// The markers come on top of the title, which is already cut to 50
+	// characters, and only the title is shortened to fit the width.
+	width := m.Width() - s.NormalTitle.GetPaddingLeft() - s.NormalTitle.GetPaddingRight()
+	markers := d.markers(memo)
+	title := ansi.Truncate(memo.Title(), max(width-lipgloss.Width(markers), 0), "…")
+	if markers != "" {
+		inline := titleStyle.Inline(true)
+		title = inline.Foreground(colorPrimary).Render(markers) + inline.Render(title)
+	}
+
+	if d.ShowDescription {
+		fmt.Fprintf(w, "%s\n%s", titleStyle.Render(title), descStyle.Render(ansi.Truncate(desc, width, "…")))
+		return
+	}
+	fmt.Fprint(w, titleStyle.Render(title))
 }
 
-func loadMemos(s *Storage) tea.Cmd {
-	return func() tea.Msg {
-		data, err := s.Load()
-		return loadMemosMsg{data, err}
+// markers are the check mark and star shown in front of a memo's title.
+func (d memoDelegate) markers(memo Memo) string {
+	var markers string
+	if _, ok := d.marked[memo.ID]; ok {
+		markers += "✓ "
 	}
+	if memo.Starred {
+		markers += d.starMarker + " "
+	}
+	return markers
 }
 
-func saveMemos(s *Storage, data *MemoData) tea.Cmd {
-	return func() tea.Msg {
-		return saveCompleteMsg{s.Save(data)}
+// ageColor is the description color for a memo last updated at t. Selected
+// memos keep the primary color regardless.
+func (d memoDelegate) ageColor(t time.Time) lipgloss.Color {
+	age := time.Since(t)
+	switch {
+	case d.staleAfter > 0 && age >= d.staleAfter:
+		return colorStale
+	case d.mutedAfter > 0 && age >= d.mutedAfter:
+		return colorMuted
+	default:
+		return colorText
 	}
 }
 
-// UI --------------------------------------------------------------------------
+// matchSnippet cuts a single line of about width runes out of content around
+// the first match, and returns it with the matched positions moved to match.
+func matchSnippet(content string, matches []int, width int) (string, []int) {
+	runes := []rune(content)
+	if len(matches) == 0 || width <= 0 {
+		return "", nil
+	}
 
-var (
-	colorPrimary    = lipgloss.Color("#FCB53B")
-	colorLineNumber = lipgloss.Color("240")
-	colorText       = lipgloss.Color("250")
-	colorMuted      = lipgloss.Color("241")
-	colorBackground = lipgloss.Color("#1c1b1c")
-	colorEndBuffer  = lipgloss.Color("237")
-
-	appStyle   = lipgloss.NewStyle().Padding(1, 2)
-	titleStyle = lipgloss.NewStyle().Bold(true).Foreground(colorPrimary)
+	start := max(matches[0]-width/4, 0)
+	end := min(start+width, len(runes))
+	if start > end {
+		return "", nil
+	}
 
-	editTitleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(colorPrimary).
-			PaddingLeft(2).
-			PaddingBottom(1)
+	var b strings.Builder
+	offset := -start
+	if start > 0 {
+		b.WriteString("…")
+		offset++
+	}
+	for _, r := range runes[start:end] {
+		if r == '\n' || r == '\t' {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
 
-	helpStyle = lipgloss.NewStyle().Foreground(colorMuted).MarginTop(1)
-)
+	positions := make([]int, 0, len(matches))
+	for _, i := range matches {
+		if i >= start && i < end {
+			positions = append(positions, i+offset)
+		}
+	}
+	return b.String(), positions
+}
 
-func newList(items []list.Item) list.Model {
+func newDelegate(cfg Config) memoDelegate {
 	d := list.NewDefaultDelegate()
 
 	d.Styles.SelectedTitle = d.Styles.SelectedTitle.
@@ -493,21 +5527,136 @@ func newList(items []list.Item) list.Model {
 		Foreground(colorPrimary).
 		BorderLeftForeground(colorPrimary)
 
-	l := list.New(items, d, 0, 0)
-	l.Title = "Yellow"
+	if cfg.Compact {
+		d.ShowDescription = false
+		d.SetSpacing(0)
+	}
+
+	return memoDelegate{
+		DefaultDelegate: d,
+		showCreated:     cfg.ShowCreated,
+		relative:        cfg.RelativeTime,
+		mutedAfter:      cfg.MutedAfter(),
+		staleAfter:      cfg.StaleAfter(),
+		starMarker:      cfg.StarMarker,
+	}
+}
+
+func newList(title string, items []list.Item, cfg Config) list.Model {
+	l := list.New(items, newDelegate(cfg), 0, 0)
+	l.Title = title
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(true)
+	l.Filter = filterMemos
 	l.SetShowHelp(false)
 	l.Styles.Title = titleStyle
 	l.Styles.FilterPrompt = lipgloss.NewStyle().Foreground(colorPrimary).Bold(true)
 	l.Styles.FilterCursor = lipgloss.NewStyle().Foreground(colorPrimary)
-	l.Styles.DefaultFilterCharacterMatch = lipgloss.NewStyle().Foreground(colorPrimary).Underline(true).Bold(true)
+	l.Styles.DefaultFilterCharacterMatch = matchStyle
 	l.FilterInput.PromptStyle = lipgloss.NewStyle().Foreground(colorPrimary)
 	l.FilterInput.Cursor.Style = lipgloss.NewStyle().Foreground(colorPrimary)
 
 	return l
 }
 
+func newTrashList(items []list.Item, cfg Config) list.Model {
+	l := newList("Trash", items, cfg)
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+func newArchiveList(items []list.Item, cfg Config) list.Model {
+	l := newList("Archive", items, cfg)
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+// dueItem is a memo listed by its due date.
+type dueItem struct {
+	Memo
+	due time.Time
+}
+
+type memoView struct {
+	Memo
+	title, desc string
+}
+
+func (v memoView) Title() string       { return v.title }
+func (v memoView) Description() string { return v.desc }
+
+// dueDelegate describes memos by how soon they are due, and shows overdue
+// ones in the warning color.
+type dueDelegate struct {
+	list.DefaultDelegate
+}
+
+func (d dueDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	if it, ok := item.(dueItem); ok {
+		y, mo, day := time.Now().Date()
+		today := time.Date(y, mo, day, 0, 0, 0, 0, time.Local)
+		days := int(math.Round(it.due.Sub(today).Hours() / 24))
+		desc := "due " + it.due.Format("2006-01-02")
+		switch {
+		case days < 0:
+			desc += fmt.Sprintf(" • overdue by %s", plural(-days, "day"))
+			d.Styles.NormalDesc = d.Styles.NormalDesc.Foreground(colorWarning)
+			d.Styles.SelectedDesc = d.Styles.SelectedDesc.Foreground(colorWarning)
+		case days == 0:
+			desc += " • today"
+		default:
+			desc += fmt.Sprintf(" • in %s", plural(days, "day"))
+		}
+		item = memoView{it.Memo, it.Title(), desc}
+	}
+	d.DefaultDelegate.Render(w, m, index, item)
+}
+
+func newDueList() list.Model {
+	l := newList("Due", make([]list.Item, 0, 8), DefaultConfig())
+	l.SetDelegate(dueDelegate{newDelegate(DefaultConfig()).DefaultDelegate})
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+// dueToItems lists the memos that have a due date, soonest first.
+func dueToItems(memos []Memo) []list.Item {
+	due := make([]dueItem, 0, len(memos))
+	for i := range memos {
+		if t, ok := memos[i].Due(); ok {
+			due = append(due, dueItem{memos[i], t})
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].due.Before(due[j].due) })
+
+	items := make([]list.Item, len(due))
+	for i := range due {
+		items[i] = due[i]
+	}
+	return items
+}
+
+func newTemplateList() list.Model {
+	l := newList("Templates", make([]list.Item, 0, 8), DefaultConfig())
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+func newTagList() list.Model {
+	l := newList("Tags", make([]list.Item, 0, 16), DefaultConfig())
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+func newTextinput(prompt string) textinput.Model {
+	ti := textinput.New()
+	ti.Prompt = prompt
+	ti.PromptStyle = lipgloss.NewStyle().Foreground(colorPrimary)
+	ti.TextStyle = lipgloss.NewStyle().Foreground(colorText)
+	ti.Cursor.Style = lipgloss.NewStyle().Foreground(colorPrimary)
+	return ti
+}
+
 func newTextarea() textarea.Model {
 	ta := textarea.New()
 	ta.Placeholder = "Start typing ..."
@@ -529,15 +5678,187 @@ func newTextarea() textarea.Model {
 
 // Utils -----------------------------------------------------------------------
 
-func truncate(s string, max int) string {
-	if len(s) <= max {
+// truncate shortens s to at most limit runes plus an ellipsis, breaking at
+// the last space when that keeps at least half of the text.
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
 		return s
 	}
-	return s[:max] + "..."
+
+	cut := string([]rune(s)[:limit])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, unicode.IsSpace) + "…"
+}
+
+// singleLine turns line breaks and tabs into spaces and drops other control
+// characters, so the text fits on one line.
+func singleLine(runes []rune) []rune {
+	out := make([]rune, 0, len(runes))
+	for i, r := range runes {
+		switch {
+		case r == '\n' && i > 0 && runes[i-1] == '\r':
+		case r == '\n', r == '\r', r == '\t':
+			out = append(out, ' ')
+		case !unicode.IsControl(r):
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// filterMemos ranks memos whose body contains the term ahead of the remaining
+// fuzzy matches, so words buried deep in long memos surface reliably. Both
+// ignore case and accents, so "cafe" finds "Café".
+func filterMemos(term string, targets []string) []list.Rank {
+	needle, _ := fold(string(singleLine([]rune(term))))
+	folded := make([]string, len(targets))
+	origins := make([][]int, len(targets))
+	for i, target := range targets {
+		folded[i], origins[i] = fold(target)
+	}
+
+	ranks := make([]list.Rank, 0, len(targets))
+	matched := make(map[int]struct{})
+
+	for i, target := range folded {
+		idx := strings.Index(target, needle)
+		if idx == -1 {
+			continue
+		}
+		start := utf8.RuneCountInString(target[:idx])
+		indexes := make([]int, utf8.RuneCountInString(needle))
+		for j := range indexes {
+			indexes[j] = start + j
+		}
+		ranks = append(ranks, list.Rank{Index: i, MatchedIndexes: unfold(indexes, origins[i])})
+		matched[i] = struct{}{}
+	}
+
+	for _, rank := range list.DefaultFilter(needle, folded) {
+		if _, ok := matched[rank.Index]; !ok {
+			rank.MatchedIndexes = unfold(rank.MatchedIndexes, origins[rank.Index])
+			ranks = append(ranks, rank)
+		}
+	}
+	return ranks
+}
+
+// fold lowercases s and strips its accents. It also returns, for each rune of
+// the result, the index of the rune of s it came from.
+func fold(s string) (string, []int) {
+	var b strings.Builder
+	origin := make([]int, 0, len(s))
+	i := 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(unicode.ToLower(r))
+			origin = append(origin, i)
+		} else {
+			// Decomposing splits an accented letter into the letter and
+			// combining marks, which are dropped.
+			for _, d := range norm.NFD.String(string(r)) {
+				if unicode.Is(unicode.Mn, d) {
+					continue
+				}
+				b.WriteRune(unicode.ToLower(d))
+				origin = append(origin, i)
+			}
+		}
+		i++
+	}
+	return b.String(), origin
+}
+
+// unfold maps rune indexes into a folded string back to the original.
+func unfold(indexes, origin []int) []int {
+	out := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if i < len(origin) && (len(out) == 0 || out[len(out)-1] != origin[i]) {
+			out = append(out, origin[i])
+		}
+	}
+	return out
+}
+
+// warnOverLimit logs memos longer than the character limit. They are kept
+// as-is so that no content is lost.
+func warnOverLimit(memos []Memo, limit int) {
+	if limit <= 0 {
+		return
+	}
+	for i := range memos {
+		if n := utf8.RuneCountInString(memos[i].Content); n > limit {
+			log.Printf("Warning: memo %s has %d characters, over the limit of %d", memos[i].ID, n, limit)
+		}
+	}
+}
+
+// RelativeTime describes t relative to now, like "3 minutes ago" or
+// "yesterday". Anything older than a year is shown as a date.
+func RelativeTime(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < 48*time.Hour:
+		return "yesterday"
+	case d < 7*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day") + " ago"
+	case d < 30*24*time.Hour:
+		return plural(int(d/(7*24*time.Hour)), "week") + " ago"
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month") + " ago"
+	}
+	return t.Format("2006-01-02")
+}
+
+// formatSize formats a byte count with a binary unit, like "4.2 KB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// trimTrailingSpace strips whitespace from the end of every line and drops
+// trailing blank lines. Leading indentation is kept.
+func trimTrailingSpace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func countWords(s string) int {
+	return len(strings.Fields(s))
 }
 
+// generateID returns the current time in nanoseconds with a random suffix,
+// so IDs created within the same nanosecond don't collide.
 func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
 }
 
 func memosToItems(memos []Memo) []list.Item {
@@ -548,35 +5869,401 @@ func memosToItems(memos []Memo) []list.Item {
 	return items
 }
 
+// tagsToItems counts the tags across memos, most used first.
+func tagsToItems(memos []Memo) []list.Item {
+	tags := countTags(memos)
+	items := make([]list.Item, len(tags))
+	for i := range tags {
+		items[i] = tags[i]
+	}
+	return items
+}
+
+// templatesToItems lists templates by name.
+func templatesToItems(templates map[string]string) []list.Item {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]list.Item, len(names))
+	for i, name := range names {
+		items[i] = templateItem{name, templates[name]}
+	}
+	return items
+}
+
+// countTags returns the tags used in memos, most used first.
+func countTags(memos []Memo) []tagItem {
+	counts := make(map[string]int)
+	for i := range memos {
+		for _, tag := range memos[i].Tags() {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]tagItem, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, tagItem{name, count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].count != tags[j].count {
+			return tags[i].count > tags[j].count
+		}
+		return tags[i].name < tags[j].name
+	})
+	return tags
+}
+
+func sortMemos(memos []Memo, mode SortMode) {
+	switch mode {
+	case SortByCreated:
+		sort.SliceStable(memos, func(i, j int) bool {
+			return memos[i].CreatedAt.After(memos[j].CreatedAt)
+		})
+	case SortByTitle:
+		sort.SliceStable(memos, func(i, j int) bool {
+			return strings.ToLower(memos[i].Title()) < strings.ToLower(memos[j].Title())
+		})
+	default:
+		sortMemosNewestFirst(memos)
+	}
+}
+
+// archiveOverflow archives the least recently updated memos beyond limit and
+// logs each one it archives. Starred memos don't count towards the limit and
+// are never archived.
+func archiveOverflow(active, archived []Memo, limit int) ([]Memo, []Memo) {
+	if len(active) <= limit {
+		return active, archived
+	}
+
+	sortMemosNewestFirst(active)
+	kept, n := 0, 0
+	for _, memo := range active {
+		if memo.Starred || kept < limit {
+			if !memo.Starred {
+				kept++
+			}
+			active[n] = memo
+			n++
+			continue
+		}
+		log.Printf("Auto-archived memo %s (%q), over the limit of %d active memos", memo.ID, memo.Title(), limit)
+		memo.Archived = true
+		archived = append(archived, memo)
+	}
+	return active[:n], archived
+}
+
 func sortMemosNewestFirst(memos []Memo) {
 	sort.Slice(memos, func(i, j int) bool {
 		return memos[i].UpdatedAt.After(memos[j].UpdatedAt)
 	})
 }
 
-func setupLogging() error {
-	logPath, err := getDataFilePath("yellow.log")
+// logOff turns logging off when given as the log path.
+const logOff = "off"
+
+// logKeep is how many rotated logs are kept, as yellow.log.1 and so on.
+const logKeep = 2
+
+// setupLogging appends the log to path, or to yellow.log in the data
+// directory if path is empty, and returns the log file. With path "off"
+// nothing is logged and the file is nil.
+func setupLogging(path string) (*os.File, error) {
+	if path == logOff {
+		log.SetOutput(io.Discard)
+		return nil, nil
+	}
+
+	logPath, err := expandHome(path)
+	if path == "" {
+		logPath, err = getDataFilePath("yellow.log")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get log path: %w", err)
+		return nil, fmt.Errorf("failed to get log path: %w", err)
 	}
+	return openLog(logPath)
+}
 
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+func openLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 	log.SetOutput(f)
-	return nil
+	return f, nil
+}
+
+// rotateLog starts the log afresh once it has grown past maxSize bytes,
+// shifting the old logs to .1 and .2. It is done at startup, after the config
+// that sets maxSize was read into the log being rotated.
+func rotateLog(f *os.File, maxSize int64) (*os.File, error) {
+	if f == nil || maxSize <= 0 {
+		return f, nil
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() <= maxSize {
+		return f, err
+	}
+
+	// The log itself is closed from here on, so failures are returned for the
+	// caller to report rather than logged, and nothing is written to it should
+	// reopening fail.
+	path := f.Name()
+	log.SetOutput(io.Discard)
+	f.Close()
+	var shiftErr error
+	for i := logKeep; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", path, i-1), fmt.Sprintf("%s.%d", path, i))
+		if err != nil && !os.IsNotExist(err) && shiftErr == nil {
+			shiftErr = fmt.Errorf("failed to shift old log file: %w", err)
+		}
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		// Keep appending to the same file rather than losing the log.
+		f, openErr := openLog(path)
+		return f, cmp.Or(openErr, fmt.Errorf("failed to rotate log file: %w", err))
+	}
+	f, err = openLog(path)
+	return f, cmp.Or(err, shiftErr)
 }
 
 // Main ------------------------------------------------------------------------
 
+// version is set at release time with -ldflags "-X main.version=v1.2.0".
+var version = "dev"
+
+// versionInfo describes the build: the version, the Go toolchain and, when
+// built from a checkout, the commit it was built from.
+func versionInfo() string {
+	v := version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Sprintf("yellow %s (%s, %s/%s)", v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	}
+	// go install module@version records the version itself.
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+
+	out := fmt.Sprintf("yellow %s (%s, %s/%s)", v, info.GoVersion, runtime.GOOS, runtime.GOARCH)
+	settings := make(map[string]string)
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	if rev := settings["vcs.revision"]; rev != "" {
+		out += "\ncommit " + rev
+		if t := settings["vcs.time"]; t != "" {
+			out += " from " + t
+		}
+		if settings["vcs.modified"] == "true" {
+			out += " (modified)"
+		}
+	}
+	return out
+}
+
 func main() {
-	if err := setupLogging(); err != nil {
+	dataFile := flag.String("file", "", "path to the memo file, or to a SQLite database if it ends in .db (default ~/.local/share/yellow/yellow.json)")
+	dbFile := flag.String("db", "", "keep memos in this SQLite database, importing the memo file into it on first use")
+	local := flag.Bool("local", false, "use .yellow.json in the current directory as the memo file")
+	exportPath := flag.String("export", "", "write memos to a Markdown file and exit")
+	includeDeleted := flag.Bool("include-deleted", false, "include deleted memos in --export")
+	importPath := flag.String("import", "", "add memos from a text file, separated by --- lines, and exit")
+	printID := flag.String("print", "", "print the memo with this id to stdout and exit")
+	encrypt := flag.Bool("encrypt", false, "encrypt the memo file with a passphrase (also set by YELLOW_PASSPHRASE)")
+	newMemo := flag.Bool("new", false, "open straight on a new memo and quit once it is saved")
+	noColor := flag.Bool("no-color", false, "disable colors (also set by the NO_COLOR environment variable)")
+	showVersion := flag.Bool("version", false, "print version and build information and exit")
+	logPath := flag.String("log", os.Getenv("YELLOW_LOG"), "append the log to this file, or \"off\" to disable logging (also set by YELLOW_LOG; default ~/.local/share/yellow/yellow.log)")
+	notebook := flag.String("notebook", "", "open this notebook, or add and import to it (default \"default\"); list, stats and --export cover all notebooks unless it is set")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionInfo())
+		return
+	}
+
+	logFile, err := setupLogging(*logPath)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not set up logging: %v\n", err)
 	}
 
-	p := tea.NewProgram(InitialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	if *local && *dataFile == "" {
+		*dataFile = localDataFile
+	}
+	dataPath := resolveDataPath(*dataFile)
+
+	cfg := DefaultConfig()
+	if configPath, err := getConfigFilePath("config.json"); err != nil {
+		log.Printf("Error getting config path: %v, using defaults", err)
+	} else if cfg, err = LoadConfig(configPath); err != nil {
+		log.Printf("Error loading config: %v, using defaults", err)
+	}
+	timeFormat = cfg.TimeFormat
+	if logFile, err = rotateLog(logFile, cfg.LogMaxSize()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	if themePath, err := getConfigFilePath("theme.json"); err != nil {
+		log.Printf("Error getting theme path: %v, using default theme", err)
+	} else if theme, err := LoadTheme(themePath); err != nil {
+		log.Printf("Error loading theme: %v, using default theme", err)
+	} else {
+		applyTheme(theme)
+	}
+
+	// Styles keep their bold, italics and borders; only colors are dropped.
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+
+	var storage fileBackend
+	if dbPath, jsonPath := databasePaths(*dbFile, dataPath); dbPath != "" {
+		if *encrypt {
+			fmt.Fprintln(os.Stderr, "Error: a SQLite database can't be encrypted")
+			os.Exit(1)
+		}
+		db := NewSQLiteStorage(dbPath, jsonPath, cfg.Retention())
+		defer db.Close()
+		storage, dataPath = db, dbPath
+	} else {
+		s := NewStorage(dataPath, cfg.Retention())
+		s.SetBackups(cfg.BackupInterval(), cfg.BackupKeep)
+		if err := setupEncryption(s, *encrypt); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		storage = s
+	}
+
+	// Commands that only read run without the lock, so loading must not
+	// write the memo file until the lock is taken; another instance may be
+	// saving it.
+	storage.SetReadOnly(true)
+
+	if *exportPath != "" {
+		if err := exportToFile(storage, *notebook, *exportPath, *includeDeleted); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *printID != "" {
+		if err := printMemo(storage, *printID, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Read-only commands run without taking the lock.
+	readOnly := map[string]func(Backend, string, []string, io.Writer) error{
+		"list":  runList,
+		"stats": runStats,
+	}
+	if run, ok := readOnly[flag.Arg(0)]; ok {
+		if err := run(storage, *notebook, flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := storage.Lock(); errors.Is(err, ErrLocked) {
+		fmt.Fprintf(os.Stderr, "Error: %s is already open in another yellow instance\n", dataPath)
+		os.Exit(1)
+	} else if err != nil {
+		log.Printf("Warning: could not lock memo file: %v", err)
+	}
+	defer storage.Unlock()
+	storage.SetReadOnly(false)
+
+	// Reads cover every notebook unless one was named, writes go to the
+	// default notebook.
+	target := *notebook
+	if target == "" {
+		target = defaultNotebook
+	}
+
+	if *importPath != "" {
+		n, err := importFromFile(storage, target, *importPath, cfg.CharLimit)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: %s does not exist, nothing imported\n", *importPath)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d memos\n", n)
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "add":
+		if err := runAdd(storage, target, flag.Args()[1:], os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "", "daily":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	state := State{}
+	if statePath, err := getDataFilePath("state.json"); err != nil {
+		log.Printf("Error getting state path: %v", err)
+	} else if state, err = LoadState(statePath); err != nil {
+		log.Printf("Error loading state: %v", err)
+	}
+	if *notebook != "" || state.Notebook == "" {
+		state.Notebook = target
+	}
+
+	m := InitialModel(storage, cfg, state)
+	if flag.Arg(0) == "daily" {
+		m = m.Daily()
+	} else if *newMemo {
+		m = m.QuickCapture()
+	}
+
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	if s, ok := storage.(*Storage); ok {
+		if stop, err := watchStorage(s, p.Send); err != nil {
+			log.Printf("Warning: could not watch memo file: %v", err)
+		} else {
+			defer stop()
+		}
+	}
+	final, err := p.Run()
+	if flushErr := m.Flush(); flushErr != nil {
+		log.Printf("Error saving: %v", flushErr)
+		fmt.Fprintf(os.Stderr, "Error: failed to save memos: %v\n", flushErr)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	if m, ok := final.(Model); ok {
+		state.Notebook = m.notebook
+		state.Sort = m.sortMode.String()
+		state.Preview = m.hasFlag(flagShowPreview)
+		state.LastSelected = ""
+		if item := m.list.SelectedItem(); item != nil {
+			state.LastSelected = item.(Memo).ID
+		}
+		if err := state.Save(); err != nil {
+			log.Printf("Warning: failed to save state: %v", err)
+		}
+	}
 }
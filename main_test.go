@@ -0,0 +1,1226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// memoData returns data with the given active memos.
+func memoData(memos ...Memo) *MemoData {
+	data := newMemoData()
+	data.Notebooks[defaultNotebook].Active = memos
+	return data
+}
+
+// newTestModel returns a model sized for an 80x24 terminal that has loaded
+// data from a memo file in a temporary directory.
+func newTestModel(t *testing.T, cfg Config, data *MemoData) Model {
+	t.Helper()
+	backend := &MemoryStorage{}
+	if err := backend.Save(data); err != nil {
+		t.Fatal(err)
+	}
+	return loadTestModel(backend, cfg)
+}
+
+// loadTestModel returns a model sized for an 80x24 terminal that has loaded
+// the memos of backend.
+func loadTestModel(backend Backend, cfg Config) Model {
+	m := InitialModel(backend, cfg, State{})
+	m = update(m, tea.WindowSizeMsg{Width: 80, Height: 24})
+	return update(m, loadMemos(m.saver)())
+}
+
+func update(m Model, msg tea.Msg) Model {
+	model, _ := m.Update(msg)
+	return model.(Model)
+}
+
+var testKeys = map[string]tea.KeyType{
+	"down":      tea.KeyDown,
+	"enter":     tea.KeyEnter,
+	"esc":       tea.KeyEsc,
+	"right":     tea.KeyRight,
+	"delete":    tea.KeyDelete,
+	"backspace": tea.KeyBackspace,
+	"tab":       tea.KeyTab,
+	"ctrl+c":    tea.KeyCtrlC,
+}
+
+// keyMsg returns the message for a key. Names without a key type are typed
+// as text.
+func keyMsg(key string) tea.KeyMsg {
+	if typ, ok := testKeys[key]; ok {
+		return tea.KeyMsg{Type: typ}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+}
+
+// press sends keys to m one at a time.
+func press(m Model, keys ...string) Model {
+	m, _ = pressCmds(m, keys...)
+	return m
+}
+
+// pressCmds is press, also returning the commands the keys gave.
+func pressCmds(m Model, keys ...string) (Model, []tea.Cmd) {
+	var cmds []tea.Cmd
+	for _, key := range keys {
+		model, cmd := m.Update(keyMsg(key))
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	}
+	return m, cmds
+}
+
+// run runs cmds concurrently, as the program would, and returns the messages
+// they give once all have finished, or within wait. Slower ones, like cursor
+// blinks and autosave ticks, are dropped.
+func run(wait time.Duration, cmds ...tea.Cmd) []tea.Msg {
+	var (
+		mu   sync.Mutex
+		msgs []tea.Msg
+		wg   sync.WaitGroup
+	)
+	var start func(cmds []tea.Cmd)
+	start = func(cmds []tea.Cmd) {
+		for _, cmd := range cmds {
+			if cmd == nil {
+				continue
+			}
+			wg.Go(func() {
+				switch msg := cmd().(type) {
+				case tea.BatchMsg:
+					start(msg)
+				case nil:
+				default:
+					mu.Lock()
+					msgs = append(msgs, msg)
+					mu.Unlock()
+				}
+			})
+		}
+	}
+	start(cmds)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(msgs)
+}
+
+func TestFilterKeptAfterEditing(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+	}{
+		{"unchanged", []string{"enter", "esc"}},
+		{"edited", []string{"enter", " again", "esc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := memoData(
+				Memo{ID: "a", Content: "apple pie", UpdatedAt: time.Now().Add(-time.Hour)},
+				Memo{ID: "b", Content: "banana", UpdatedAt: time.Now().Add(-2 * time.Hour)},
+				Memo{ID: "c", Content: "apple tart", UpdatedAt: time.Now().Add(-3 * time.Hour)},
+			)
+			m := newTestModel(t, DefaultConfig(), data)
+			// Sorted by title, the edited memo keeps its place.
+			m.sortMode = SortByTitle
+			m.refreshLists()
+			m.list.SetFilterText("apple")
+			m.list.CursorDown()
+			want := m.list.SelectedItem().(Memo).ID
+
+			m = press(m, tt.keys...)
+			if m.list.FilterState() != list.FilterApplied || m.list.FilterValue() != "apple" {
+				t.Errorf("filter %q in state %v, want \"apple\" applied", m.list.FilterValue(), m.list.FilterState())
+			}
+			if got := m.list.SelectedItem().(Memo).ID; got != want {
+				t.Errorf("selected %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigSaveSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // empty for no config file
+		want    map[string]any
+	}{
+		{
+			name: "no config file",
+			want: map[string]any{"show_created": true},
+		},
+		{
+			name:    "other options kept",
+			content: `{"autosave_seconds": 10, "char_limit": 200}`,
+			want:    map[string]any{"autosave_seconds": 10.0, "char_limit": 200.0, "show_created": true},
+		},
+		{
+			name:    "unknown option kept",
+			content: `{"show_created": false, "future_option": "x"}`,
+			want:    map[string]any{"show_created": true, "future_option": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if tt.content != "" {
+				writeFile(t, filepath.Dir(path), "config.json", tt.content)
+			}
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cfg.SaveSetting("show_created", true); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config file = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateIDUnique(t *testing.T) {
+	seen := make(map[string]struct{}, 100_000)
+	for range 100_000 {
+		id := generateID()
+		if _, ok := seen[id]; ok {
+			t.Fatalf("generateID() returned %s twice", id)
+		}
+		seen[id] = struct{}{}
+	}
+}
+
+func TestLoadMigratesLegacyFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"bare array", `[{"id": "a", "content": "legacy"}]`},
+		{"unversioned object", `{"active": [{"id": "a", "content": "legacy"}]}`},
+		{"version 2", `{"schema_version": 2, "active": [{"id": "a", "content": "legacy"}], "deleted": [], "archived": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "yellow.json", tt.content)
+			if _, err := NewStorage(path, 0).Load(); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got MemoData
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatalf("memo file not rewritten as an object: %v\n%s", err, raw)
+			}
+			nb := got.Notebooks[defaultNotebook]
+			if got.SchemaVersion != currentSchemaVersion || nb == nil || len(nb.Active) != 1 || nb.Active[0].Content != "legacy" {
+				t.Errorf("memo file = %s, want the memo in the default notebook at version %d", raw, currentSchemaVersion)
+			}
+
+			// Migrated once, the file is left alone from then on.
+			if _, err := NewStorage(path, 0).Load(); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := os.ReadFile(path); !bytes.Equal(again, raw) {
+				t.Errorf("memo file rewritten on the second load:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestConcurrentSaves(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(t *testing.T) Backend
+	}{
+		{"memory", func(t *testing.T) Backend { return &MemoryStorage{} }},
+		{"file", func(t *testing.T) Backend { return NewStorage(filepath.Join(t.TempDir(), "yellow.json"), 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := tt.backend(t)
+			s := NewSaver(backend)
+			if _, err := s.Load(); err != nil {
+				t.Fatal(err)
+			}
+
+			const n = 50
+			versions := make([]uint64, n)
+			snapshots := make([]*MemoData, n)
+			for i := range n {
+				snapshots[i] = memoData(Memo{ID: "a", Content: strconv.Itoa(i)})
+				versions[i] = s.nextVersion(snapshots[i])
+			}
+			var wg sync.WaitGroup
+			for i := range n {
+				wg.Go(func() {
+					if err := s.saveVersion(versions[i], snapshots[i]); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+			wg.Wait()
+
+			got, err := backend.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nb := got.Notebook(defaultNotebook); len(nb.Active) != 1 || nb.Active[0].Content != strconv.Itoa(n-1) {
+				t.Errorf("backend holds %+v, want the newest snapshot", nb.Active)
+			}
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "js", "wasip1", "plan9":
+		t.Skip("file locking is not supported on " + runtime.GOOS)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, path string)
+		want  error
+	}{
+		{"no lock file", func(t *testing.T, path string) {}, nil},
+		{"stale lock file", func(t *testing.T, path string) {
+			writeFile(t, filepath.Dir(path), filepath.Base(path)+".lock", "")
+		}, nil},
+		{"held by another instance", func(t *testing.T, path string) {
+			other := NewStorage(path, 0)
+			if err := other.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { other.Unlock() })
+		}, ErrLocked},
+		{"released by another instance", func(t *testing.T, path string) {
+			other := NewStorage(path, 0)
+			if err := other.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			if err := other.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "yellow.json")
+			tt.setup(t, path)
+
+			s := NewStorage(path, 0)
+			err := s.Lock()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Lock() = %v, want %v", err, tt.want)
+			}
+			if err == nil {
+				if err := s.Unlock(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadOnlyLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+		rewrite  bool
+	}{
+		{"read-only command", true, false},
+		{"locked instance", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const legacy = `[{"id": "a", "content": "legacy"}]`
+			path := writeFile(t, t.TempDir(), "yellow.json", legacy)
+			s := NewStorage(path, 0)
+			s.SetReadOnly(tt.readOnly)
+
+			data, err := s.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nb := data.Notebook(defaultNotebook); len(nb.Active) != 1 {
+				t.Errorf("loaded %+v, want the legacy memo", nb.Active)
+			}
+			raw, _ := os.ReadFile(path)
+			if rewritten := string(raw) != legacy; rewritten != tt.rewrite {
+				t.Errorf("memo file rewritten: %v, want %v", rewritten, tt.rewrite)
+			}
+		})
+	}
+}
+
+func TestReloadDropsPendingSave(t *testing.T) {
+	tests := []struct {
+		name   string
+		reload func(s *Saver)
+	}{
+		{"reload read after the save was queued", func(s *Saver) { s.Load() }},
+		{"save queued before the reload was applied", func(s *Saver) { s.DropPending() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &MemoryStorage{}
+			s := NewSaver(backend)
+			s.Load()
+
+			stale := saveMemos(s, memoData(Memo{ID: "a", Content: "local"}), time.Millisecond)
+			backend.Save(memoData(Memo{ID: "b", Content: "external"}))
+			tt.reload(s)
+
+			if msg := stale(); msg != nil {
+				t.Errorf("stale save ran: %#v", msg)
+			}
+			if err := s.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := backend.Load()
+			if nb := got.Notebook(defaultNotebook); len(nb.Active) != 1 || nb.Active[0].Content != "external" {
+				t.Errorf("backend holds %+v, want the external change", nb.Active)
+			}
+		})
+	}
+}
+
+// stored flushes m and returns the default notebook as saved.
+func stored(t *testing.T, m Model) *Notebook {
+	t.Helper()
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data.Notebook(defaultNotebook)
+}
+
+// countingStorage is a MemoryStorage that counts its writes, and fails the
+// first fail of them with err.
+type countingStorage struct {
+	MemoryStorage
+	writes atomic.Int32
+	fail   int32
+	err    error
+}
+
+func (s *countingStorage) Save(data *MemoData) error {
+	if s.writes.Add(1) <= s.fail {
+		return s.err
+	}
+	return s.MemoryStorage.Save(data)
+}
+
+// memoAt returns a memo created and last updated days ago.
+func memoAt(id string, days int) Memo {
+	at := time.Now().AddDate(0, 0, -days)
+	return Memo{ID: id, Content: id, CreatedAt: at, UpdatedAt: at}
+}
+
+func TestListPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		wantPage int
+	}{
+		{"first page", nil, 1},
+		{"right", []string{"right"}, 2},
+		{"l", []string{"l"}, 2},
+		{"typing a filter", []string{"/", "l"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memos := make([]Memo, 100)
+			for i := range memos {
+				memos[i] = memoAt(strconv.Itoa(i), i)
+			}
+			m := press(newTestModel(t, DefaultConfig(), memoData(memos...)), tt.keys...)
+
+			pages := m.list.Paginator.TotalPages
+			if pages < 2 {
+				t.Fatalf("%d pages, want enough memos for several", pages)
+			}
+			if want := fmt.Sprintf("page %d/%d", tt.wantPage, pages); !strings.Contains(m.statusBarView(), want) {
+				t.Errorf("status bar %q, want %q", m.statusBarView(), want)
+			}
+		})
+	}
+}
+
+func TestLoadCorruptFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"garbage", "not json at all"},
+		{"truncated", `{"schema_version": 3, "notebooks": {"default": {"active": [`},
+		{"wrong type", `{"schema_version": "three"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeFile(t, dir, "yellow.json", tt.content)
+			s := NewStorage(path, 0)
+
+			_, err := s.Load()
+			var corrupt *CorruptFileError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("Load() error = %v, want a CorruptFileError", err)
+			}
+			backup, err := os.ReadFile(corrupt.Backup)
+			if err != nil || string(backup) != tt.content {
+				t.Errorf("backup = %q, %v, want the corrupt content", backup, err)
+			}
+			if data, _ := os.ReadFile(path); string(data) != tt.content {
+				t.Errorf("memo file = %q, want it left alone", data)
+			}
+
+			// Reloading the same file reuses the backup, even one made
+			// earlier.
+			earlier := path + ".bak.20000101-000000"
+			if err := os.Rename(corrupt.Backup, earlier); err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.Load()
+			if !errors.As(err, &corrupt) || corrupt.Backup != earlier {
+				t.Fatalf("second Load() error = %v, want a CorruptFileError with backup %s", err, earlier)
+			}
+			if backups, _ := filepath.Glob(path + ".bak.*"); len(backups) != 1 {
+				t.Errorf("backups = %v, want one", backups)
+			}
+		})
+	}
+}
+
+func TestKeyMapValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rebind   func(k *KeyMap)
+		defaults bool
+	}{
+		{"unique keys", func(k *KeyMap) { k.New = Keys{"n"} }, false},
+		{"bound twice", func(k *KeyMap) { k.New = Keys{"q"} }, true},
+		{"ctrl+c", func(k *KeyMap) { k.Save = Keys{"ctrl+c"} }, true},
+		{"list esc", func(k *KeyMap) { k.Undo = Keys{"esc"} }, true},
+		{"list filter", func(k *KeyMap) { k.Tags = Keys{"/"} }, true},
+		{"trash empty", func(k *KeyMap) { k.Trash = Keys{"X"} }, true},
+		{"trash restore", func(k *KeyMap) { k.Quit = Keys{"r"} }, true},
+		{"archive enter", func(k *KeyMap) { k.Edit = Keys{"o"}; k.Archive = Keys{"enter"} }, true},
+		{"templates enter", func(k *KeyMap) { k.Edit = Keys{"o"}; k.Template = Keys{"enter"} }, true},
+		{"editor esc", func(k *KeyMap) { k.Save = Keys{"ctrl+s"}; k.Clear = Keys{"esc"} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := DefaultKeyMap()
+			tt.rebind(&k)
+			got := k.validate()
+			if isDefault := reflect.DeepEqual(got, DefaultKeyMap()); isDefault != tt.defaults {
+				t.Errorf("validate() = %+v, want defaults: %v", got, tt.defaults)
+			}
+		})
+	}
+}
+
+func TestCloseUnchangedMemo(t *testing.T) {
+	contents := []string{
+		"col1\tcol2\nline with trailing  ",
+		"windows\r\nline endings",
+		"trailing blank lines\n\n",
+		"trailing spaces and blank lines  \n\n",
+	}
+	closes := []struct {
+		name  string
+		close func(m Model) Model
+	}{
+		{"esc", func(m Model) Model { return press(m, "esc") }},
+		{"autosave", func(m Model) Model { return update(m, autosaveMsg{tag: m.autosaveTag}) }},
+	}
+	for _, content := range contents {
+		for _, c := range closes {
+			t.Run(fmt.Sprintf("%s %q", c.name, content), func(t *testing.T) {
+				memo := memoAt("a", 1)
+				memo.Content = content
+				m := press(newTestModel(t, DefaultConfig(), memoData(memo)), "enter")
+				if m.isModified() {
+					t.Error("memo is modified as soon as it is opened")
+				}
+				m = c.close(m)
+
+				if got := m.memos; len(got) != 1 || got[0].Content != content {
+					t.Errorf("memos %+v, want the content kept as %q", got, content)
+				}
+			})
+		}
+	}
+}
+
+func TestCloseEditedMemo(t *testing.T) {
+	memo := memoAt("a", 1)
+	memo.Content = "col1\tcol2"
+	m := press(newTestModel(t, DefaultConfig(), memoData(memo)), "enter", "!", "esc")
+
+	got := m.memos
+	if len(got) != 1 || got[0].Content != "col1    col2!" || !got[0].UpdatedAt.After(memo.UpdatedAt) {
+		t.Errorf("memos %+v, want the edit saved", got)
+	}
+}
+
+func TestTinyWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+	}{
+		{"list", nil},
+		{"preview", []string{"p"}},
+		{"editor", []string{"enter"}},
+		{"trash", []string{"t"}},
+		{"archive", []string{"A"}},
+		{"tags", []string{"#"}},
+		{"reader", []string{"v"}},
+		{"replace", []string{"R"}},
+		{"help", []string{"?"}},
+	}
+	for _, tt := range tests {
+		for _, size := range []tea.WindowSizeMsg{{Width: 1, Height: 1}, {Width: 2, Height: 3}} {
+			t.Run(fmt.Sprintf("%s %dx%d", tt.name, size.Width, size.Height), func(t *testing.T) {
+				m := newTestModel(t, DefaultConfig(), memoData(memoAt("a", 1)))
+				m = press(update(m, size), tt.keys...)
+				m.View()
+
+				if m.list.Width() < 0 || m.list.Height() < 0 {
+					t.Errorf("list is %dx%d", m.list.Width(), m.list.Height())
+				}
+				if m.textarea.Width() < 0 || m.textarea.Height() < 0 {
+					t.Errorf("textarea is %dx%d", m.textarea.Width(), m.textarea.Height())
+				}
+				if m.reader.Width < 0 || m.reader.Height < 0 || m.keyHelp.Width < 0 || m.keyHelp.Height < 0 {
+					t.Errorf("viewports are %dx%d and %dx%d", m.reader.Width, m.reader.Height, m.keyHelp.Width, m.keyHelp.Height)
+				}
+			})
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"word boundary", "A long memo title that goes on", 20, "A long memo title…"},
+		{"long word", "supercalifragilistic", 10, "supercalif…"},
+		{"space too early", "a verylongwordwithoutspaces", 10, "a verylong…"},
+		{"multibyte", "日本語のテキストです", 4, "日本語の…"},
+		{"accents", "Café au lait crème brûlée", 15, "Café au lait…"},
+		{"emoji", "🙂🙂🙂🙂", 2, "🙂🙂…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncate(%q, %d) = %q, not valid UTF-8", tt.s, tt.limit, got)
+			}
+		})
+	}
+}
+
+func TestMemoTitle(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"first line", "Groceries\nmilk", "Groceries"},
+		{"leading blank lines", "\n\n  \nGroceries", "Groceries"},
+		{"heading", "# Plans\nnext week", "Plans"},
+		{"deeper heading", "### Plans", "Plans"},
+		{"empty heading", "#\nPlans", "Plans"},
+		{"hashtag", "#plans for today", "#plans for today"},
+		{"blank", " \n\t\n", "(empty memo)"},
+		{"long line", strings.Repeat("word ", 20), "word word word word word word word word word word…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Memo{Content: tt.content}).Title(); got != tt.want {
+				t.Errorf("Title() of %q = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickChangesWriteOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+	}{
+		{"stars", []string{"*", "*", "*"}},
+		{"archives", []string{"a", "a"}},
+		{"edits", []string{"enter", "!", "esc", "enter", "?", "esc"}},
+		{"deletes", []string{"delete", "y", "delete", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &countingStorage{}
+			backend.MemoryStorage.Save(memoData(memoAt("a", 1), memoAt("b", 2)))
+			cfg := DefaultConfig()
+			cfg.SaveDelayMs = 100
+			m := loadTestModel(backend, cfg)
+
+			// Keys come in a few milliseconds apart, each starting its
+			// commands right away.
+			var wg sync.WaitGroup
+			for _, key := range tt.keys {
+				var cmds []tea.Cmd
+				m, cmds = pressCmds(m, key)
+				wg.Go(func() { run(300*time.Millisecond, cmds...) })
+				time.Sleep(5 * time.Millisecond)
+			}
+			wg.Wait()
+			if n := backend.writes.Load(); n != 1 {
+				t.Errorf("%d writes, want 1", n)
+			}
+			if err := m.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if n := backend.writes.Load(); n != 1 {
+				t.Errorf("%d writes after flushing, want 1", n)
+			}
+		})
+	}
+}
+
+func TestQuitKeepsChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want func(nb *Notebook) bool
+	}{
+		{"editing", []string{"enter", "!", "ctrl+c"}, func(nb *Notebook) bool {
+			return len(nb.Active) == 1 && nb.Active[0].Content == "a!"
+		}},
+		{"new memo", []string{"tab", "new", "ctrl+c"}, func(nb *Notebook) bool {
+			return len(nb.Active) == 2 && slices.ContainsFunc(nb.Active, func(m Memo) bool { return m.Content == "new" })
+		}},
+		{"starred in the list", []string{"*", "q"}, func(nb *Notebook) bool {
+			return len(nb.Active) == 1 && nb.Active[0].Starred
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.SaveDelayMs = 1000
+			m, cmds := pressCmds(newTestModel(t, cfg, memoData(memoAt("a", 1))), tt.keys...)
+			if _, ok := cmds[len(cmds)-1]().(tea.QuitMsg); !ok {
+				t.Fatal("the last key didn't quit")
+			}
+
+			// Nothing runs the save commands; main flushes after quitting.
+			if nb := stored(t, m); !tt.want(nb) {
+				t.Errorf("stored %+v, want the last change", nb.Active)
+			}
+		})
+	}
+}
+
+func TestFilterMemos(t *testing.T) {
+	targets := []string{"Café au lait", "CAFETERIA menu", "green tea", "Crème brûlée"}
+	tests := []struct {
+		term string
+		want []int
+	}{
+		{"cafe", []int{0, 1}},
+		{"CAFÉ", []int{0, 1}},
+		{"creme", []int{3}},
+		{"BRÛLEE", []int{3}},
+		{"tea", []int{2, 1}},
+		{"cfl", []int{0}},
+		{"xyz", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			var got []int
+			for _, rank := range filterMemos(tt.term, targets) {
+				got = append(got, rank.Index)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filterMemos(%q) matched %v, want %v", tt.term, got, tt.want)
+			}
+		})
+	}
+
+	// Matches are marked on the original text, accents and all.
+	ranks := filterMemos("brulee", targets)
+	if len(ranks) != 1 || !slices.Equal(ranks[0].MatchedIndexes, []int{6, 7, 8, 9, 10, 11}) {
+		t.Errorf("filterMemos(\"brulee\") = %+v, want runes 6 to 11 of %q", ranks, targets[3])
+	}
+}
+
+func TestActiveLimit(t *testing.T) {
+	deletedAt := time.Now()
+	tests := []struct {
+		name         string
+		data         *MemoData
+		keys         []string
+		wantActive   int
+		wantArchived []string
+	}{
+		{
+			name:       "load over the limit",
+			data:       memoData(memoAt("a", 1), memoAt("b", 2), memoAt("c", 3)),
+			wantActive: 3,
+		},
+		{
+			name: "unarchive at the limit",
+			data: &MemoData{Notebooks: map[string]*Notebook{defaultNotebook: {
+				Active:   []Memo{memoAt("a", 1), memoAt("b", 2)},
+				Archived: []Memo{{ID: "c", Content: "c", Archived: true}},
+			}}},
+			keys:       []string{"A", "enter"},
+			wantActive: 3,
+		},
+		{
+			name: "restore at the limit",
+			data: &MemoData{Notebooks: map[string]*Notebook{defaultNotebook: {
+				Active:  []Memo{memoAt("a", 1), memoAt("b", 2)},
+				Deleted: []Memo{{ID: "c", Content: "c", DeletedAt: &deletedAt}},
+			}}},
+			keys:       []string{"t", "enter"},
+			wantActive: 3,
+		},
+		{
+			name:         "new memo over the limit",
+			data:         memoData(memoAt("a", 1), memoAt("b", 2)),
+			keys:         []string{"tab", "new", "esc"},
+			wantActive:   2,
+			wantArchived: []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxActive = 2
+			m := press(newTestModel(t, cfg, tt.data), tt.keys...)
+
+			nb := stored(t, m)
+			if len(nb.Active) != tt.wantActive {
+				t.Errorf("%d active memos, want %d", len(nb.Active), tt.wantActive)
+			}
+			var archived []string
+			for _, memo := range nb.Archived {
+				if memo.ID != "c" {
+					archived = append(archived, memo.ID)
+				}
+			}
+			if !slices.Equal(archived, tt.wantArchived) {
+				t.Errorf("archived %v, want %v", archived, tt.wantArchived)
+			}
+		})
+	}
+}
+
+func TestFailingWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       int32
+		err        error
+		wantWrites int32
+		wantSaved  bool
+	}{
+		{"fatal error", 1, os.ErrNotExist, 1, false},
+		{"transient error", 2, syscall.ENOSPC, 3, true},
+		{"transient error that lasts", saveRetries + 1, syscall.EACCES, saveRetries + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &countingStorage{fail: tt.fail, err: tt.err}
+			backend.MemoryStorage.Save(memoData(memoAt("a", 1)))
+			cfg := DefaultConfig()
+			cfg.SaveDelayMs = 0
+			m, cmds := pressCmds(loadTestModel(backend, cfg), "*")
+
+			for _, msg := range run(10*time.Second, cmds...) {
+				m = update(m, msg)
+			}
+			if n := backend.writes.Load(); n != tt.wantWrites {
+				t.Errorf("%d writes, want %d", n, tt.wantWrites)
+			}
+			if !m.memos[0].Starred {
+				t.Error("the change was lost from the model")
+			}
+			if unsaved := strings.Contains(m.statusBarView(), "Unsaved changes"); unsaved == tt.wantSaved {
+				t.Errorf("status bar %q, want it to show unsaved changes: %v", m.statusBarView(), !tt.wantSaved)
+			}
+
+			// Once the backend works again, the next change saves both.
+			m, cmds = pressCmds(m, "tab", "more", "esc")
+			for _, msg := range run(100*time.Millisecond, cmds...) {
+				m = update(m, msg)
+			}
+			if nb := stored(t, m); len(nb.Active) != 2 || !slices.ContainsFunc(nb.Active, func(m Memo) bool { return m.Starred }) {
+				t.Errorf("stored %+v, want both changes", nb.Active)
+			}
+			if strings.Contains(m.statusBarView(), "Unsaved changes") {
+				t.Errorf("status bar %q after a successful save", m.statusBarView())
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&os.PathError{Op: "open", Path: "yellow.json", Err: syscall.ENOSPC}, true},
+		{&os.PathError{Op: "open", Path: "yellow.json", Err: syscall.EACCES}, true},
+		{&os.PathError{Op: "open", Path: "yellow.json", Err: syscall.ENOENT}, false},
+		{errors.New("bad data"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNoChangeNoWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+	}{
+		{"esc without edits", []string{"enter", "esc"}},
+		{"edit undone", []string{"enter", "!", "backspace", "esc"}},
+		{"new memo left empty", []string{"tab", "esc"}},
+		{"trash opened", []string{"t", "esc"}},
+		{"sort", []string{"s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &countingStorage{}
+			backend.MemoryStorage.Save(memoData(memoAt("a", 1), memoAt("b", 2)))
+			cfg := DefaultConfig()
+			cfg.SaveDelayMs = 0
+			m, cmds := pressCmds(loadTestModel(backend, cfg), tt.keys...)
+
+			run(100*time.Millisecond, cmds...)
+			if err := m.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if n := backend.writes.Load(); n != 0 {
+				t.Errorf("%d writes, want none", n)
+			}
+		})
+	}
+}
+
+func TestOpenCloseKeepsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"plain", "groceries\nmilk"},
+		{"tabs and trailing spaces", "col1\tcol2\nline with trailing  "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "yellow.json")
+			memo := memoAt("a", 1)
+			memo.Content = tt.content
+			s := NewStorage(path, 0)
+			if err := s.Save(memoData(memo)); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(path)
+			stat, _ := os.Stat(path)
+
+			cfg := DefaultConfig()
+			cfg.SaveDelayMs = 0
+			m, cmds := pressCmds(loadTestModel(s, cfg), "enter", "esc", "enter", "esc")
+			for _, msg := range run(100*time.Millisecond, cmds...) {
+				if _, ok := msg.(saveCompleteMsg); ok {
+					t.Error("closing the unchanged memo queued a save")
+				}
+			}
+			if err := m.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			after, _ := os.ReadFile(path)
+			info, _ := os.Stat(path)
+			if !bytes.Equal(after, before) || !info.ModTime().Equal(stat.ModTime()) {
+				t.Errorf("memo file rewritten:\n%s", after)
+			}
+		})
+	}
+}
+
+func TestUpdatedOnlyWhenEdited(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		wantFirst string
+		wantBump  bool
+	}{
+		{"viewed", []string{"down", "enter", "esc"}, "a", false},
+		{"autosaved unchanged", []string{"down", "enter", "autosave", "esc"}, "a", false},
+		{"edited", []string{"down", "enter", "!", "esc"}, "b", true},
+		{"replaced with itself", []string{"R", "b", "enter", "b", "enter", "y"}, "a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := memoAt("b", 2)
+			m := newTestModel(t, DefaultConfig(), memoData(memoAt("a", 1), b))
+			for _, key := range tt.keys {
+				if key == "autosave" {
+					m = update(m, autosaveMsg{tag: m.autosaveTag})
+					continue
+				}
+				m = press(m, key)
+			}
+
+			if first := m.list.Items()[0].(Memo).ID; first != tt.wantFirst {
+				t.Errorf("%s listed first, want %s", first, tt.wantFirst)
+			}
+			active := stored(t, m).Active
+			i := slices.IndexFunc(active, func(m Memo) bool { return m.ID == "b" })
+			if bumped := !active[i].UpdatedAt.Equal(b.UpdatedAt); bumped != tt.wantBump {
+				t.Errorf("UpdatedAt changed: %v, want %v", bumped, tt.wantBump)
+			}
+		})
+	}
+}
+
+func TestFilterPaste(t *testing.T) {
+	tests := []struct {
+		name  string
+		paste string
+		want  string
+	}{
+		{"newline", "apple\npie", "apple pie"},
+		{"windows newline", "apple\r\npie", "apple pie"},
+		{"tab", "apple\tpie", "apple pie"},
+		{"control characters", "apple\x00\x1b pie", "apple pie"},
+		{"only control characters", "\x00\x07", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := press(newTestModel(t, DefaultConfig(), memoData(Memo{ID: "a", Content: "apple pie"})), "/")
+			m = update(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(tt.paste), Paste: true})
+
+			if m.list.FilterState() != list.Filtering {
+				t.Fatalf("filter state %v, want still filtering", m.list.FilterState())
+			}
+			if got := m.list.FilterInput.Value(); got != tt.want {
+				t.Errorf("filter = %q, want %q", got, tt.want)
+			}
+			if view := m.list.FilterInput.View(); strings.ContainsAny(view, "\r\n\t") {
+				t.Errorf("filter prompt spans lines: %q", view)
+			}
+		})
+	}
+}
+
+func TestSQLiteImport(t *testing.T) {
+	dir := t.TempDir()
+	deletedAt := time.Now().Add(-time.Hour).Round(0)
+	want := memoData(memoAt("a", 1), memoAt("b", 2))
+	want.Notebook(defaultNotebook).Deleted = []Memo{{ID: "c", Content: "gone", DeletedAt: &deletedAt}}
+	want.Notebook(defaultNotebook).Archived = []Memo{{ID: "d", Content: "old", Archived: true, Starred: true}}
+	want.Notebook("work").Active = []Memo{{ID: "e", Content: "# 2001-02-03", Daily: "2001-02-03"}}
+	want.Notebook("empty")
+	jsonPath := filepath.Join(dir, "yellow.json")
+	if err := NewStorage(jsonPath, 0).Save(want); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(jsonPath)
+	dbPath := filepath.Join(dir, "yellow.db")
+
+	// Commands without the lock read the memo file instead of creating the
+	// database.
+	readOnly := NewSQLiteStorage(dbPath, jsonPath, 0)
+	readOnly.SetReadOnly(true)
+	if got, err := readOnly.Load(); err != nil || !got.sameMemos(want) {
+		t.Fatalf("read-only Load() = %+v, %v, want the memo file", got, err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("read-only Load() created the database: %v", err)
+	}
+
+	s := NewSQLiteStorage(dbPath, jsonPath, 0)
+	got, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if !got.sameMemos(want) || got.Notebooks["empty"] == nil {
+		t.Errorf("imported %+v, want %+v", got.Notebooks, want.Notebooks)
+	}
+	if after, _ := os.ReadFile(jsonPath); !bytes.Equal(after, before) {
+		t.Error("the memo file was changed by the import")
+	}
+
+	// The import happens once; later changes to the memo file are ignored.
+	if err := NewStorage(jsonPath, 0).Save(memoData()); err != nil {
+		t.Fatal(err)
+	}
+	s = NewSQLiteStorage(dbPath, jsonPath, 0)
+	defer s.Close()
+	if got, err := s.Load(); err != nil || !got.sameMemos(want) {
+		t.Errorf("reopened database holds %+v, %v, want the imported memos", got, err)
+	}
+}
+
+func TestSQLiteSave(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(data *MemoData)
+		wantChanges int
+	}{
+		{"nothing", func(data *MemoData) {}, 0},
+		{"edit", func(data *MemoData) {
+			data.Notebooks[defaultNotebook].Active[5].Content = "edited"
+		}, 1},
+		{"add", func(data *MemoData) {
+			nb := data.Notebooks[defaultNotebook]
+			nb.Active = append(nb.Active, Memo{ID: "new", Content: "new"})
+		}, 1},
+		{"delete", func(data *MemoData) {
+			nb := data.Notebooks[defaultNotebook]
+			memo := nb.Active[7]
+			memo.DeletedAt = new(time.Time)
+			nb.Active = slices.Delete(nb.Active, 7, 8)
+			nb.Deleted = append(nb.Deleted, memo)
+		}, 1},
+		{"remove", func(data *MemoData) {
+			nb := data.Notebooks[defaultNotebook]
+			nb.Active = slices.Delete(nb.Active, 0, 1)
+		}, 1},
+		{"new notebook", func(data *MemoData) { data.Notebook("work") }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := NewSQLiteStorage(filepath.Join(dir, "yellow.db"), filepath.Join(dir, "yellow.json"), 0)
+			defer s.Close()
+			memos := make([]Memo, 100)
+			for i := range memos {
+				memos[i] = memoAt(strconv.Itoa(i), i)
+			}
+			if _, err := s.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save(memoData(memos...)); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := s.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(data)
+			var before, after int
+			s.db.QueryRow("SELECT total_changes()").Scan(&before)
+			if err := s.Save(data); err != nil {
+				t.Fatal(err)
+			}
+			s.db.QueryRow("SELECT total_changes()").Scan(&after)
+			if after-before != tt.wantChanges {
+				t.Errorf("%d rows written, want %d", after-before, tt.wantChanges)
+			}
+
+			reopened := NewSQLiteStorage(s.path, "", 0)
+			defer reopened.Close()
+			if got, err := reopened.Load(); err != nil || !got.sameMemos(data) {
+				t.Errorf("database holds %+v, %v, want the saved memos", got, err)
+			}
+		})
+	}
+}
+
+func TestRotateLog(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	path := filepath.Join(t.TempDir(), "yellow.log")
+	os.WriteFile(path+".1", []byte("older"), 0666)
+	f, err := openLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Print(strings.Repeat("x", 100))
+
+	f, err = rotateLog(f, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	log.Print("after")
+
+	for name, want := range map[string]string{path: "after", path + ".1": "xxx", path + ".2": "older"} {
+		data, err := os.ReadFile(name)
+		if err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("%s holds %q, want %q (%v)", filepath.Base(name), data, want, err)
+		}
+	}
+}